@@ -0,0 +1,202 @@
+package alerts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+)
+
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is the state of a single rule.
+type Alert struct {
+	Rule  Rule    `json:"rule"`
+	State State   `json:"state"`
+	Value float64 `json:"value"`
+	// Since is when the alert entered its current state
+	Since      time.Time `json:"since"`
+	FiredAt    time.Time `json:"firedAt,omitempty"`
+	ResolvedAt time.Time `json:"resolvedAt,omitempty"`
+	// Stale is set while the series has no recent datapoints, the alert
+	// keeps its state until it has.
+	Stale bool `json:"stale"`
+}
+
+// Active reports whether the alert is pending or firing.
+func (a Alert) Active() bool {
+	return a.State == StatePending || a.State == StateFiring
+}
+
+// Engine evaluates a set of rules against the series returned by lookup.
+type Engine struct {
+	lookup func(name string) *series.Series
+
+	mu     sync.RWMutex
+	alerts []*Alert
+	maxAge time.Duration
+}
+
+// NewEngine parses rules, which maps the rule names to their expressions.
+func NewEngine(rules map[string]string, lookup func(name string) *series.Series) (*Engine, error) {
+	e := &Engine{lookup: lookup}
+	if err := e.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetRules replaces the rules of the engine. Alerts of rules whose name and
+// expression didn't change keep their state. If any of the rules is
+// invalid nothing is changed.
+func (e *Engine) SetRules(rules map[string]string) error {
+	parsed := make([]Rule, 0, len(rules))
+	errs := make([]string, 0)
+	for name, expr := range rules {
+		r, err := ParseRule(name, expr)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		parsed = append(parsed, r)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid alert rules: %s", strings.Join(errs, ", "))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev := make(map[string]*Alert)
+	for _, a := range e.alerts {
+		prev[a.Rule.Name] = a
+	}
+
+	as := make([]*Alert, 0, len(parsed))
+	for _, r := range parsed {
+		if a, ok := prev[r.Name]; ok && a.Rule.Expr == r.Expr {
+			as = append(as, a)
+			continue
+		}
+		as = append(as, &Alert{Rule: r, State: StateInactive})
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Rule.Name < as[j].Rule.Name })
+	e.alerts = as
+
+	return nil
+}
+
+// SetMaxAge sets how old the latest datapoint of a series may be for its
+// rules to be evaluated, 0 evaluates datapoints of any age.
+func (e *Engine) SetMaxAge(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.maxAge = d
+}
+
+// Evaluate checks every rule against the latest datapoint of its series and
+// returns the alerts that changed their state. Rules whose series doesn't
+// exist or has no datapoints yet keep their state, as do stale rules whose
+// latest datapoint is older than the max age.
+func (e *Engine) Evaluate(now time.Time) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changed := make([]Alert, 0)
+	for _, a := range e.alerts {
+		s := e.lookup(a.Rule.Series)
+		if s == nil || s.Datapoints.Len() == 0 {
+			continue
+		}
+		latest := s.Datapoints.Latest()
+		a.Stale = e.maxAge > 0 && now.Sub(time.Unix(int64(latest.X), 0)) > e.maxAge
+		if a.Stale {
+			continue
+		}
+		a.Value = latest.Y
+
+		if e.step(a, now) {
+			changed = append(changed, *a)
+		}
+	}
+
+	return changed
+}
+
+// step moves a to its next state, it reports whether the state changed.
+func (e *Engine) step(a *Alert, now time.Time) bool {
+	prev := a.State
+
+	switch a.State {
+	case StateInactive, StateResolved:
+		if a.Rule.matches(a.Value) {
+			a.State = StatePending
+			a.Since = now
+		}
+	case StatePending:
+		if !a.Rule.matches(a.Value) {
+			a.State = StateInactive
+			a.Since = now
+		}
+	case StateFiring:
+		if a.Rule.cleared(a.Value) {
+			a.State = StateResolved
+			a.Since = now
+			a.ResolvedAt = now
+		}
+	}
+
+	if a.State == StatePending && now.Sub(a.Since) >= a.Rule.For {
+		a.State = StateFiring
+		a.Since = now
+		a.FiredAt = now
+	}
+
+	return a.State != prev
+}
+
+// Alerts returns the state of every rule, sorted by name.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	as := make([]Alert, len(e.alerts))
+	for i, a := range e.alerts {
+		as[i] = *a
+	}
+	return as
+}
+
+// Stale returns the alerts whose series has no recent datapoints.
+func (e *Engine) Stale() []Alert {
+	stale := make([]Alert, 0)
+	for _, a := range e.Alerts() {
+		if a.Stale {
+			stale = append(stale, a)
+		}
+	}
+	return stale
+}
+
+// Active returns the pending and firing alerts.
+func (e *Engine) Active() []Alert {
+	active := make([]Alert, 0)
+	for _, a := range e.Alerts() {
+		if a.Active() {
+			active = append(active, a)
+		}
+	}
+	return active
+}
@@ -0,0 +1,198 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("hot", "cpuTemp > 80 for 5m clear 75")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Series != "cpuTemp" || r.Op != ">" || r.Threshold != 80 || r.For != 5*time.Minute {
+		t.Errorf("parsed %+v", r)
+	}
+	if r.Clear == nil || *r.Clear != 75 {
+		t.Errorf("clear should be 75")
+	}
+
+	r, err = ParseRule("full", "diskUsage.data >= 95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.For != 0 || r.Clear != nil {
+		t.Errorf("parsed %+v", r)
+	}
+
+	invalid := []string{
+		"",
+		"cpuTemp > ",
+		"cpuTemp ~ 80",
+		"cpuTemp > hot",
+		"cpuTemp > 80 for",
+		"cpuTemp > 80 for ever",
+		"cpuTemp > 80 until 5m",
+		"cpuTemp > 80 clear 85",
+	}
+	for _, expr := range invalid {
+		if _, err := ParseRule("invalid", expr); err == nil {
+			t.Errorf("%q should be invalid", expr)
+		}
+	}
+}
+
+func TestNewEngine_Invalid(t *testing.T) {
+	_, err := NewEngine(map[string]string{"ok": "cpuTemp > 80", "broken": "cpuTemp >"}, nil)
+	if err == nil {
+		t.Fatal("engine with an invalid rule should fail")
+	}
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	s := series.NewSeries("cpuTemp", 100)
+	e, err := NewEngine(map[string]string{"hot": "cpuTemp > 80 for 5m clear 75"}, func(name string) *series.Series {
+		if name == s.Name {
+			return s
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	steps := []struct {
+		after   time.Duration
+		value   float64
+		state   State
+		changed bool
+	}{
+		{0, 70, StateInactive, false},
+		{1 * time.Minute, 85, StatePending, true},
+		{2 * time.Minute, 79, StateInactive, true},
+		{3 * time.Minute, 85, StatePending, true},
+		{7 * time.Minute, 90, StatePending, false},
+		{8 * time.Minute, 90, StateFiring, true},
+		// below the threshold but above the clear value
+		{9 * time.Minute, 78, StateFiring, false},
+		{10 * time.Minute, 85, StateFiring, false},
+		{11 * time.Minute, 74, StateResolved, true},
+		{12 * time.Minute, 74, StateResolved, false},
+		{13 * time.Minute, 81, StatePending, true},
+	}
+
+	for i, st := range steps {
+		now := start.Add(st.after)
+		s.Push(now, st.value)
+
+		changed := e.Evaluate(now)
+		if (len(changed) > 0) != st.changed {
+			t.Errorf("step %d: changed is %v", i, changed)
+		}
+
+		a := e.Alerts()[0]
+		if a.State != st.state {
+			t.Fatalf("step %d: state is %s but should be %s", i, a.State, st.state)
+		}
+		if a.Value != st.value {
+			t.Errorf("step %d: value is %f but should be %f", i, a.Value, st.value)
+		}
+	}
+
+	if len(e.Active()) != 1 {
+		t.Errorf("pending alert should be active")
+	}
+}
+
+func TestEngine_Immediate(t *testing.T) {
+	s := series.NewSeries("memoryUsage", 10)
+	e, err := NewEngine(map[string]string{"memory": "memoryUsage >= 90"}, func(string) *series.Series { return s })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no datapoints yet
+	if changed := e.Evaluate(time.Now()); len(changed) != 0 {
+		t.Errorf("rule without datapoints should not change")
+	}
+
+	s.Push(time.Now(), 95)
+	changed := e.Evaluate(time.Now())
+	if len(changed) != 1 || changed[0].State != StateFiring {
+		t.Errorf("rule without duration should fire at once, got %v", changed)
+	}
+}
+
+func TestEngine_SetRules(t *testing.T) {
+	s := series.NewSeries("cpuTemp", 10)
+	e, err := NewEngine(map[string]string{
+		"hot":     "cpuTemp > 80",
+		"cold":    "cpuTemp < 10",
+		"removed": "cpuTemp > 0",
+	}, func(string) *series.Series { return s })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Push(time.Now(), 90)
+	e.Evaluate(time.Now())
+
+	if err := e.SetRules(map[string]string{"hot": "cpuTemp >"}); err == nil {
+		t.Fatal("invalid rule should fail")
+	}
+	if len(e.Alerts()) != 3 {
+		t.Fatal("failed SetRules should keep the old rules")
+	}
+
+	if err := e.SetRules(map[string]string{"hot": "cpuTemp > 80", "cold": "cpuTemp < 20"}); err != nil {
+		t.Fatal(err)
+	}
+	as := e.Alerts()
+	if len(as) != 2 {
+		t.Fatalf("got %d alerts but should be 2", len(as))
+	}
+	// sorted by name, changed rules start over
+	if as[0].Rule.Name != "cold" || as[0].Rule.Expr != "cpuTemp < 20" {
+		t.Errorf("cold rule not updated: %+v", as[0])
+	}
+	if as[1].State != StateFiring {
+		t.Errorf("unchanged rule should keep its state, got %s", as[1].State)
+	}
+}
+
+func TestEngine_Stale(t *testing.T) {
+	s := series.NewSeries("cpuTemp", 10)
+	e, err := NewEngine(map[string]string{"hot": "cpuTemp > 80"}, func(string) *series.Series { return s })
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetMaxAge(30 * time.Second)
+
+	start := time.Now()
+	s.Push(start, 85)
+	if changed := e.Evaluate(start); len(changed) != 1 || changed[0].State != StateFiring {
+		t.Fatalf("rule should fire, got %v", changed)
+	}
+
+	// the collector stopped, the old value must not resolve or fire anything
+	s.Push(start.Add(10*time.Second), 70)
+	changed := e.Evaluate(start.Add(time.Minute))
+	if len(changed) != 0 {
+		t.Errorf("stale rule should not change, got %v", changed)
+	}
+	if a := e.Alerts()[0]; !a.Stale || a.State != StateFiring || a.Value != 85 {
+		t.Errorf("expected a stale firing alert at 85, got %+v", a)
+	}
+	if len(e.Stale()) != 1 {
+		t.Errorf("stale alert should be reported")
+	}
+
+	s.Push(start.Add(time.Minute), 70)
+	changed = e.Evaluate(start.Add(time.Minute))
+	if len(changed) != 1 || changed[0].State != StateResolved || changed[0].Stale {
+		t.Errorf("rule should resolve with new datapoints, got %v", changed)
+	}
+}
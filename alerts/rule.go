@@ -0,0 +1,111 @@
+package alerts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a threshold on the latest value of a series, written as
+//
+//	<series> <op> <threshold> [for <duration>] [clear <value>]
+//
+// e.g. "cpuTemp > 80 for 5m clear 75". The condition has to hold for the
+// whole duration before the alert fires. A firing alert only resolves once
+// the value has crossed the clear value, so it doesn't flap around the
+// threshold. Without a clear value it resolves as soon as the condition no
+// longer holds.
+type Rule struct {
+	Name      string        `json:"name"`
+	Expr      string        `json:"expr"`
+	Series    string        `json:"series"`
+	Op        string        `json:"op"`
+	Threshold float64       `json:"threshold"`
+	For       time.Duration `json:"for"`
+	Clear     *float64      `json:"clear,omitempty"`
+}
+
+var (
+	ops = map[string]func(v, t float64) bool{
+		">":  func(v, t float64) bool { return v > t },
+		">=": func(v, t float64) bool { return v >= t },
+		"<":  func(v, t float64) bool { return v < t },
+		"<=": func(v, t float64) bool { return v <= t },
+		"==": func(v, t float64) bool { return v == t },
+		"!=": func(v, t float64) bool { return v != t },
+	}
+)
+
+// ParseRule parses expr into a Rule called name.
+func ParseRule(name, expr string) (Rule, error) {
+	r := Rule{Name: name, Expr: expr}
+
+	fields := strings.Fields(expr)
+	if len(fields) < 3 {
+		return r, fmt.Errorf("rule %s: expected <series> <op> <threshold>, got %q", name, expr)
+	}
+
+	r.Series = fields[0]
+	r.Op = fields[1]
+	if _, ok := ops[r.Op]; !ok {
+		return r, fmt.Errorf("rule %s: unknown operator %s", name, r.Op)
+	}
+
+	t, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return r, fmt.Errorf("rule %s: invalid threshold %s", name, fields[2])
+	}
+	r.Threshold = t
+
+	rest := fields[3:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return r, fmt.Errorf("rule %s: %s needs a value", name, rest[0])
+		}
+
+		switch rest[0] {
+		case "for":
+			d, err := time.ParseDuration(rest[1])
+			if err != nil || d < 0 {
+				return r, fmt.Errorf("rule %s: invalid duration %s", name, rest[1])
+			}
+			r.For = d
+		case "clear":
+			c, err := strconv.ParseFloat(rest[1], 64)
+			if err != nil {
+				return r, fmt.Errorf("rule %s: invalid clear value %s", name, rest[1])
+			}
+			r.Clear = &c
+		default:
+			return r, fmt.Errorf("rule %s: unexpected %s", name, rest[0])
+		}
+		rest = rest[2:]
+	}
+
+	if r.Clear != nil && r.matches(*r.Clear) {
+		return r, fmt.Errorf("rule %s: clear value %g would keep the alert firing", name, *r.Clear)
+	}
+
+	return r, nil
+}
+
+// matches reports whether v meets the condition of the rule.
+func (r Rule) matches(v float64) bool {
+	return ops[r.Op](v, r.Threshold)
+}
+
+// cleared reports whether a firing alert with value v is resolved.
+func (r Rule) cleared(v float64) bool {
+	if r.Clear == nil {
+		return !r.matches(v)
+	}
+
+	switch r.Op {
+	case ">", ">=":
+		return v <= *r.Clear
+	case "<", "<=":
+		return v >= *r.Clear
+	}
+	return !r.matches(v)
+}
@@ -6,11 +6,19 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
-type BasicAuthUsers map[string]string
+// BasicAuthUsers maps user names to passwords. It's safe for concurrent use
+// and can be replaced with Set while requests are being served.
+type BasicAuthUsers struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
 
-func (b *BasicAuthUsers) PopulateFromEnv() {
+// UsersFromEnv returns the users defined by RASPI_DASH_USER_<NAME>
+// environment variables.
+func UsersFromEnv() map[string]string {
 	prefix := "RASPI_DASH_USER_"
 	envUsers := make(map[string]string)
 	for _, e := range os.Environ() {
@@ -21,13 +29,28 @@ func (b *BasicAuthUsers) PopulateFromEnv() {
 			envUsers[user] = kv[1]
 		}
 	}
-	*b = envUsers
+	return envUsers
+}
+
+func (b *BasicAuthUsers) PopulateFromEnv() {
+	b.Set(UsersFromEnv())
 }
 
-func (b BasicAuthUsers) IsAuthorized(authorization string) bool {
+// Set replaces all users.
+func (b *BasicAuthUsers) Set(users map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.users = users
+}
+
+func (b *BasicAuthUsers) IsAuthorized(authorization string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	authValue := strings.TrimRight(strings.TrimPrefix(authorization, "Basic "), "=")
 
-	for k, v := range b {
+	for k, v := range b.users {
 		valid := base64.RawStdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", k, v)))
 
 		if authValue == valid {
@@ -37,7 +60,7 @@ func (b BasicAuthUsers) IsAuthorized(authorization string) bool {
 	return false
 }
 
-func (b BasicAuthUsers) Middleware(next http.Handler) http.Handler {
+func (b *BasicAuthUsers) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if auth == "" {
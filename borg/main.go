@@ -1,26 +1,20 @@
 package borg
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"sort"
+	"syscall"
 	"time"
 )
 
-var (
-	DocumentsRepo = Repo{
-		Name:       "documents",
-		ID:         "d39u3wpc@d39u3wpc.repo.borgbase.com:repo",
-		Passphrase: "borg",
-	}
-
-	PhotosRepo = Repo{
-		Name:       "photos",
-		ID:         "cs0l58ko@cs0l58ko.repo.borgbase.com:repo",
-		Passphrase: "borg",
-	}
+const (
+	// stopTimeout is how long borg gets to exit after being told to stop
+	stopTimeout = 10 * time.Second
 )
 
 type Repo struct {
@@ -71,22 +65,47 @@ type archivesJSON struct {
 	Archives []Archive `json:"archives"`
 }
 
-func (b Repo) ListBackupArchives() ([]Archive, error) {
+// ListBackupArchives lists the archives of the repo, newest first. borg is
+// stopped if ctx is done before it finishes.
+func (b Repo) ListBackupArchives(ctx context.Context) ([]Archive, error) {
 	cmd := exec.Command("sudo", "--preserve-env=HOME,BORG_REPO,BORG_PASSPHRASE", "-u", "borg", "borg", "list", "--json")
 	cmd.Env = append(os.Environ(),
 		"HOME=/home/borg",
 		fmt.Sprintf("BORG_REPO=%s", b.ID),
 		fmt.Sprintf("BORG_PASSPHRASE=%s", b.Passphrase),
 	)
-	out, err := cmd.CombinedOutput()
-	outS := string(out)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		return []Archive{}, fmt.Errorf("cannot run borg: %w", err)
+	}
 
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// sudo passes SIGTERM on to borg, which then releases the repo
+		// lock. Killing sudo right away would leave borg running.
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+			cmd.Process.Kill()
+		}
+		return []Archive{}, fmt.Errorf("borg was stopped: %w", ctx.Err())
+	}
 	if err != nil {
-		return []Archive{}, fmt.Errorf("borg failed: %s", outS)
+		return []Archive{}, fmt.Errorf("borg failed: %s", out.String())
 	}
 
 	archives := new(archivesJSON)
-	err = json.Unmarshal(out, archives)
+	err = json.Unmarshal(out.Bytes(), archives)
 	if err != nil {
 		return []Archive{}, err
 	}
@@ -99,11 +118,14 @@ func (b Repo) ListBackupArchives() ([]Archive, error) {
 	return archives.Archives, nil
 }
 
-func (b Repo) NewestBackupArchive() (Archive, error) {
-	as, err := b.ListBackupArchives()
+func (b Repo) NewestBackupArchive(ctx context.Context) (Archive, error) {
+	as, err := b.ListBackupArchives(ctx)
 	if err != nil {
 		return Archive{}, fmt.Errorf("NewestBackupArchive cannot list backups: %w", err)
 	}
+	if len(as) == 0 {
+		return Archive{}, fmt.Errorf("NewestBackupArchive: %s has no backups", b.Name)
+	}
 
 	return as[0], nil
 }
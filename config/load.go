@@ -0,0 +1,348 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pbaettig/raspi-dash/alerts"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvPrefix = "RASPI_DASH_"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Load reads the config file at p on top of the defaults, applies the
+// environment overrides and validates the result. If p is empty only the
+// defaults and the environment are used.
+func Load(p string) (*Config, error) {
+	c := Default()
+
+	if p != "" {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config: %w", err)
+		}
+
+		dec := yaml.NewDecoder(bytes.NewReader(buf))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("cannot parse config %s: %w", p, err)
+		}
+	}
+
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	for name, expr := range c.Alerts.Rules {
+		if strings.TrimSpace(expr) == "" {
+			delete(c.Alerts.Rules, name)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// envName turns a path of yaml keys into the name of the environment
+// variable overriding it, e.g. plots.updateInterval becomes
+// RASPI_DASH_PLOTS_UPDATE_INTERVAL.
+func envName(keys ...string) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		b := new(strings.Builder)
+		rs := []rune(k)
+		for j, r := range rs {
+			if j > 0 && unicode.IsUpper(r) && unicode.IsLower(rs[j-1]) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		parts[i] = b.String()
+	}
+	return EnvPrefix + strings.Join(parts, "_")
+}
+
+// applyEnv overrides every string, number, duration and list of strings
+// with the environment variable named after its yaml keys, see envName.
+// Lists are comma separated. The passphrase of a borg repo is taken from
+// RASPI_DASH_BORG_<NAME>_PASSPHRASE.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	if err := applyEnvStruct(reflect.ValueOf(c).Elem(), nil, lookup); err != nil {
+		return err
+	}
+
+	for i, r := range c.Borg {
+		if v, ok := lookup(envName("borg", r.Name, "passphrase")); ok {
+			c.Borg[i].Passphrase = v
+		}
+	}
+	return nil
+}
+
+func applyEnvStruct(v reflect.Value, keys []string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		fkeys := append(append([]string{}, keys...), key)
+		f := v.Field(i)
+
+		if f.Kind() == reflect.Struct {
+			if err := applyEnvStruct(f, fkeys, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := envName(fkeys...)
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(f, s); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromString(f reflect.Value, s string) error {
+	switch {
+	case f.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(s)
+	case f.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+		ss := make([]string, 0)
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				ss = append(ss, e)
+			}
+		}
+		f.Set(reflect.ValueOf(ss))
+	}
+	// everything else, e.g. maps and lists of structs, can only be set in
+	// the config file
+	return nil
+}
+
+func (c *Config) validateChallenge(add func(key, format string, args ...interface{})) {
+	le := c.LetsEncrypt
+
+	switch le.Challenge {
+	case ChallengeTLSALPN, ChallengeHTTP:
+		for _, d := range le.Domains {
+			if strings.HasPrefix(d, "*.") {
+				add("letsencrypt.domains", "wildcard %s needs the %s challenge", d, ChallengeDNS)
+			}
+		}
+	case ChallengeDNS:
+		switch le.DNS.Provider {
+		case "rfc2136":
+			if le.DNS.RFC2136.Nameserver == "" {
+				add("letsencrypt.dns.rfc2136.nameserver", "must be set")
+			}
+			if (le.DNS.RFC2136.TSIGKey == "") != (le.DNS.RFC2136.TSIGSecret == "") {
+				add("letsencrypt.dns.rfc2136", "tsigKey and tsigSecret must be set together")
+			}
+		case "exec":
+			if le.DNS.Exec == "" {
+				add("letsencrypt.dns.exec", "must be set")
+			}
+		default:
+			add("letsencrypt.dns.provider", "%q is neither rfc2136 nor exec", le.DNS.Provider)
+		}
+		for _, r := range le.DNS.Resolvers {
+			if _, _, err := net.SplitHostPort(r); err != nil {
+				add("letsencrypt.dns.resolvers", "%q is not a host:port address", r)
+			}
+		}
+	default:
+		add("letsencrypt.challenge", "%q is none of %s, %s and %s", le.Challenge, ChallengeTLSALPN, ChallengeHTTP, ChallengeDNS)
+	}
+}
+
+// ValidationError lists every problem found in a config.
+type ValidationError []string
+
+func (ve ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(ve, "\n  ")
+}
+
+// Validate checks c for missing or invalid settings.
+func (c *Config) Validate() error {
+	ve := make(ValidationError, 0)
+	add := func(key, format string, args ...interface{}) {
+		ve = append(ve, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	checkAddr := func(key, addr string) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			add(key, "%q is not a host:port address", addr)
+		}
+	}
+	checkURL := func(key, u string) {
+		pu, err := url.Parse(u)
+		if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			add(key, "%q is not a http(s) URL", u)
+		}
+	}
+	checkPatterns := func(key string, ps []string) {
+		for _, p := range ps {
+			if _, err := path.Match(p, ""); err != nil {
+				add(key, "%q is not a valid pattern", p)
+			}
+		}
+	}
+	checkPositive := func(key string, d time.Duration) {
+		if d <= 0 {
+			add(key, "must be greater than 0")
+		}
+	}
+	checkSet := func(key, v string) {
+		if v == "" {
+			add(key, "must be set")
+		}
+	}
+
+	if c.Listen.HTTP != "" {
+		checkAddr("listen.http", c.Listen.HTTP)
+	}
+	if c.Listen.HTTPS != "" {
+		checkAddr("listen.https", c.Listen.HTTPS)
+	}
+	if c.Listen.Mode != ListenModeHTTPS && c.Listen.Mode != ListenModeHTTP {
+		add("listen.mode", "%q is neither %s nor %s", c.Listen.Mode, ListenModeHTTPS, ListenModeHTTP)
+	}
+	for _, p := range c.Listen.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			add("listen.trustedProxies", "%q is neither an IP address nor a network", p)
+		}
+	}
+	checkPositive("listen.shutdownTimeout", c.Listen.ShutdownTimeout)
+	checkSet("domain", c.Domain)
+
+	switch c.TLS.Mode {
+	case TLSModeACME:
+		checkSet("letsencrypt.email", c.LetsEncrypt.Email)
+		if c.LetsEncrypt.Email != "" && !strings.Contains(c.LetsEncrypt.Email, "@") {
+			add("letsencrypt.email", "%q is not an email address", c.LetsEncrypt.Email)
+		}
+	case TLSModeLocal:
+		checkSet("tls.local.caCertFile", c.TLS.Local.CACertFile)
+		checkSet("tls.local.caKeyFile", c.TLS.Local.CAKeyFile)
+		checkSet("tls.local.certFile", c.TLS.Local.CertFile)
+		checkSet("tls.local.keyFile", c.TLS.Local.KeyFile)
+		for _, h := range c.TLS.Local.Hosts {
+			if h == "" || (strings.ContainsAny(h, " /:") && net.ParseIP(h) == nil) {
+				add("tls.local.hosts", "%q is neither a hostname nor an IP address", h)
+			}
+		}
+	default:
+		add("tls.mode", "%q is neither %s nor %s", c.TLS.Mode, TLSModeACME, TLSModeLocal)
+	}
+
+	if c.LetsEncrypt.CADirURL != "" {
+		checkURL("letsencrypt.caDirURL", c.LetsEncrypt.CADirURL)
+		if c.LetsEncrypt.Staging {
+			add("letsencrypt.staging", "cannot be used together with caDirURL")
+		}
+	}
+	c.validateChallenge(add)
+	checkSet("letsencrypt.certFile", c.LetsEncrypt.CertFile)
+	checkSet("letsencrypt.keyFile", c.LetsEncrypt.KeyFile)
+	checkSet("letsencrypt.accountFile", c.LetsEncrypt.AccountFile)
+	checkPositive("letsencrypt.renewBefore", c.LetsEncrypt.RenewBefore)
+
+	checkSet("paths.documents", c.Paths.Documents)
+	checkSet("paths.seriesData", c.Paths.SeriesData)
+	checkSet("paths.sysfs", c.Paths.Sysfs)
+
+	if c.Plots.Width <= 0 || c.Plots.Height <= 0 {
+		add("plots", "width and height must be greater than 0")
+	}
+	checkPositive("plots.updateInterval", c.Plots.UpdateInterval)
+	checkPositive("intervals.backup", c.Intervals.Backup)
+	checkPositive("intervals.disk", c.Intervals.Disk)
+	checkPositive("intervals.raid", c.Intervals.Raid)
+	checkPositive("intervals.alertEval", c.Intervals.AlertEval)
+
+	checkPatterns("network.allow", c.Network.Allow)
+	checkPatterns("network.deny", c.Network.Deny)
+	checkPatterns("filesystems.types", c.Filesystems.Types)
+	checkPatterns("blockDevices.deny", c.BlockDevices.Deny)
+
+	names := make(map[string]bool)
+	for i, r := range c.Borg {
+		key := fmt.Sprintf("borg[%d]", i)
+		if r.Name == "" || r.ID == "" {
+			add(key, "name and id must be set")
+		}
+		if names[r.Name] {
+			add(key, "repo %s is configured more than once", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	for name, expr := range c.Alerts.Rules {
+		if _, err := alerts.ParseRule(name, expr); err != nil {
+			add("alerts.rules", "%s", err.Error())
+		}
+	}
+	if c.Alerts.Webhook.URL != "" {
+		checkURL("alerts.webhook.url", c.Alerts.Webhook.URL)
+	}
+	if c.Alerts.Push.URL != "" {
+		checkURL("alerts.push.url", c.Alerts.Push.URL)
+	}
+	if c.Alerts.SMTP.Addr != "" {
+		checkAddr("alerts.smtp.addr", c.Alerts.SMTP.Addr)
+		if c.Alerts.SMTP.From == "" || len(c.Alerts.SMTP.To) == 0 {
+			add("alerts.smtp", "from and to must be set")
+		}
+	}
+
+	if len(ve) > 0 {
+		sort.Strings(ve)
+		return ve
+	}
+	return nil
+}
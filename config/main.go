@@ -8,26 +8,299 @@ import (
 )
 
 const (
-	PlotSizeWidth       = 800
-	PlotSizeHeight      = 240
 	PlotTitleFontWeight = gofont.WeightBold
 	PlotTitleFontSize   = 14
 	PlotTitleFontStyle  = gofont.StyleNormal
 
-	PlotUpdateInterval   = 1 * time.Second
-	BackupUpdateInterval = 1 * time.Minute
-	PlotDatapoints       = 12 * 3600
-	PlotDataRange        = time.Duration(PlotDatapoints) * PlotUpdateInterval
+	// PlotDatapoints is the number of raw datapoints kept per series, 12h
+	// at the default update interval. Longer ranges are served from the
+	// rollups.
+	PlotDatapoints = 12 * 3600
+	PlotMaxRange   = 365 * 24 * time.Hour
 
-	DocumentsPath = "/data/share/documents/"
-	PhotosPath    = "/data/share/photos/"
+	// DefaultPath is where the config file is looked for if none is given
+	DefaultPath = "/etc/raspi-dash/config.yaml"
 
-	LetsEncryptUserName    = "pbaettig@gmail.com"
-	ExternalDomainName     = "home.caspal.ch"
-	TLSCertificateFilePath = "cert.pem"
-	TLSPrivateKeyFilePath  = "key.pem"
+	LetsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	ListenModeHTTPS = "https"
+	ListenModeHTTP  = "http"
+
+	TLSModeACME  = "acme"
+	TLSModeLocal = "local"
+
+	ChallengeTLSALPN = "tls-alpn-01"
+	ChallengeHTTP    = "http-01"
+	ChallengeDNS     = "dns-01"
 )
 
 var (
 	PlotTitleFontColor = color.RGBA{44, 44, 144, 255}
 )
+
+// Config is everything that differs between installations. It's read from
+// a YAML file, see Load, and handed to the packages that need it.
+type Config struct {
+	Listen Listen `yaml:"listen"`
+	// Domain is the name the dashboard is reached at, there is no default
+	Domain       string       `yaml:"domain"`
+	TLS          TLS          `yaml:"tls"`
+	LetsEncrypt  LetsEncrypt  `yaml:"letsencrypt"`
+	Paths        Paths        `yaml:"paths"`
+	Plots        Plots        `yaml:"plots"`
+	Intervals    Intervals    `yaml:"intervals"`
+	Network      Network      `yaml:"network"`
+	Filesystems  Filesystems  `yaml:"filesystems"`
+	BlockDevices BlockDevices `yaml:"blockDevices"`
+	Borg         []BorgRepo   `yaml:"borg"`
+	Alerts       Alerts       `yaml:"alerts"`
+	// Users maps the names of the users that may access the documents to
+	// their passwords, on top of the RASPI_DASH_USER_<NAME> variables.
+	Users map[string]string `yaml:"users"`
+}
+
+type Listen struct {
+	// HTTP and HTTPS are the addresses of the servers, e.g. ":8443" for
+	// every IPv4 and IPv6 address, "[::1]:8443" or "192.168.1.10:8443".
+	// Empty addresses aren't listened on. Sockets passed by systemd socket
+	// activation are used instead if they are named http and https.
+	HTTP  string `yaml:"http"`
+	HTTPS string `yaml:"https"`
+	// Mode is https to serve the dashboard with TLS and redirect HTTP to
+	// it, or http to serve it on HTTP only, e.g. behind a reverse proxy
+	// terminating TLS. No certificate is used in http mode.
+	Mode string `yaml:"mode"`
+	// Unix is the path of a unix socket the dashboard is served on without
+	// TLS next to the other servers, e.g. for a reverse proxy on the Pi.
+	Unix string `yaml:"unix"`
+	// TrustedProxies are the addresses and networks of the reverse proxies
+	// whose X-Forwarded-For, -Host and -Proto headers are trusted.
+	// Clients of the unix socket always are, for the last hop.
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// HTTP2 enables HTTP/2 on the HTTPS server.
+	HTTP2 bool `yaml:"http2"`
+	// ShutdownTimeout is how long running requests get to finish on
+	// shutdown before they are cut off.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// TLS chooses where the certificate of the HTTPS server comes from.
+type TLS struct {
+	// Mode is acme to request it as configured in letsencrypt, or local to
+	// issue it with a CA kept on the Pi, e.g. on a LAN without public DNS
+	Mode  string  `yaml:"mode"`
+	Local LocalCA `yaml:"local"`
+}
+
+type LocalCA struct {
+	CACertFile string `yaml:"caCertFile"`
+	CAKeyFile  string `yaml:"caKeyFile"`
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	// Hosts are the names and IP addresses the certificate is valid for
+	// next to domain
+	Hosts []string `yaml:"hosts"`
+}
+
+type LetsEncrypt struct {
+	// Email is the contact of the ACME account, required in TLSModeACME
+	Email string `yaml:"email"`
+	// Staging uses the Let's Encrypt staging CA, whose certificates aren't
+	// trusted by browsers but which has much higher rate limits.
+	Staging bool `yaml:"staging"`
+	// CADirURL is the directory of any other ACME CA, e.g. a local Pebble.
+	CADirURL string `yaml:"caDirURL"`
+	// Domains are added to the certificate next to Domain, wildcards like
+	// "*.example.com" need the dns-01 challenge.
+	Domains []string `yaml:"domains"`
+	// Challenge is one of tls-alpn-01, answered by the HTTPS server,
+	// http-01, answered by the HTTP server, or dns-01.
+	Challenge string       `yaml:"challenge"`
+	DNS       DNSChallenge `yaml:"dns"`
+	CertFile  string       `yaml:"certFile"`
+	KeyFile   string       `yaml:"keyFile"`
+	// AccountFile keeps the key and registration of the ACME account of
+	// every CA that was used
+	AccountFile string `yaml:"accountFile"`
+	// RenewBefore is how long before it expires the certificate is renewed
+	RenewBefore time.Duration `yaml:"renewBefore"`
+}
+
+// DirectoryURL returns the directory of the CA certificates are requested
+// from.
+func (l LetsEncrypt) DirectoryURL() string {
+	switch {
+	case l.CADirURL != "":
+		return l.CADirURL
+	case l.Staging:
+		return LetsEncryptStaging
+	}
+	return LetsEncryptProduction
+}
+
+// DNSChallenge configures how the TXT records of the dns-01 challenge are
+// published.
+type DNSChallenge struct {
+	// Provider is rfc2136 for dynamic updates or exec to run a hook
+	Provider string  `yaml:"provider"`
+	RFC2136  RFC2136 `yaml:"rfc2136"`
+	// Exec is run as "<exec> present|cleanup <fqdn> <value>"
+	Exec string `yaml:"exec"`
+	// Resolvers are asked whether the records have propagated, the system
+	// resolvers are used if empty.
+	Resolvers []string `yaml:"resolvers"`
+}
+
+type RFC2136 struct {
+	Nameserver string `yaml:"nameserver"`
+	TSIGKey    string `yaml:"tsigKey"`
+	TSIGSecret string `yaml:"tsigSecret"`
+	// TSIGAlgorithm defaults to hmac-md5
+	TSIGAlgorithm string `yaml:"tsigAlgorithm"`
+}
+
+type Paths struct {
+	Documents  string `yaml:"documents"`
+	SeriesData string `yaml:"seriesData"`
+	Sysfs      string `yaml:"sysfs"`
+}
+
+type Plots struct {
+	Width          int           `yaml:"width"`
+	Height         int           `yaml:"height"`
+	UpdateInterval time.Duration `yaml:"updateInterval"`
+}
+
+type Intervals struct {
+	Backup    time.Duration `yaml:"backup"`
+	Disk      time.Duration `yaml:"disk"`
+	Raid      time.Duration `yaml:"raid"`
+	AlertEval time.Duration `yaml:"alertEval"`
+}
+
+// Network holds shell patterns of the network interfaces that are
+// collected. An empty allow list allows every interface.
+type Network struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Filesystems holds shell patterns of the filesystem types whose usage is
+// collected, e.g. add "nfs*" or "tmpfs" to include network or memory
+// filesystems.
+type Filesystems struct {
+	Types []string `yaml:"types"`
+}
+
+// BlockDevices holds shell patterns of the block devices whose I/O is not
+// collected.
+type BlockDevices struct {
+	Deny []string `yaml:"deny"`
+}
+
+type BorgRepo struct {
+	Name       string `yaml:"name"`
+	ID         string `yaml:"id"`
+	Passphrase string `yaml:"passphrase"`
+}
+
+// Alerts configures the alert rules and where notifications are sent.
+// Notifiers without an address are disabled.
+type Alerts struct {
+	// Rules by name, written as
+	// "<series> <op> <threshold> [for <duration>] [clear <value>]". A rule
+	// with an empty expression is disabled.
+	Rules   map[string]string `yaml:"rules"`
+	Webhook Webhook           `yaml:"webhook"`
+	Push    Push              `yaml:"push"`
+	SMTP    SMTP              `yaml:"smtp"`
+}
+
+type Webhook struct {
+	URL string `yaml:"url"`
+}
+
+type Push struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+type SMTP struct {
+	Addr     string   `yaml:"addr"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// Default returns the configuration used for everything that isn't set in
+// the config file.
+func Default() *Config {
+	return &Config{
+		Listen: Listen{
+			HTTP:            ":8080",
+			HTTPS:           ":8443",
+			Mode:            ListenModeHTTPS,
+			TrustedProxies:  []string{},
+			HTTP2:           true,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		TLS: TLS{
+			Mode: TLSModeACME,
+			Local: LocalCA{
+				CACertFile: "ca.crt",
+				CAKeyFile:  "ca.key",
+				CertFile:   "local-cert.pem",
+				KeyFile:    "local-key.pem",
+				Hosts:      []string{},
+			},
+		},
+		LetsEncrypt: LetsEncrypt{
+			Domains:     []string{},
+			Challenge:   ChallengeTLSALPN,
+			DNS:         DNSChallenge{Resolvers: []string{}},
+			CertFile:    "cert.pem",
+			KeyFile:     "key.pem",
+			AccountFile: "account.json",
+			RenewBefore: 30 * 24 * time.Hour,
+		},
+		Paths: Paths{
+			Documents:  "/data/share/documents/",
+			SeriesData: "/var/lib/raspi-dash/series",
+			Sysfs:      "/sys",
+		},
+		Plots: Plots{
+			Width:          800,
+			Height:         240,
+			UpdateInterval: 1 * time.Second,
+		},
+		Intervals: Intervals{
+			Backup:    1 * time.Minute,
+			Disk:      10 * time.Second,
+			Raid:      5 * time.Second,
+			AlertEval: 5 * time.Second,
+		},
+		Network: Network{
+			Allow: []string{},
+			Deny:  []string{"lo", "veth*"},
+		},
+		Filesystems: Filesystems{
+			Types: []string{"ext*", "*fat", "btrfs", "xfs", "f2fs"},
+		},
+		BlockDevices: BlockDevices{
+			Deny: []string{"loop*", "ram*", "zram*"},
+		},
+		Borg: []BorgRepo{},
+		Alerts: Alerts{
+			Rules: map[string]string{
+				"CPU temperature high": "cpuTemp > 80 for 5m clear 75",
+				"Memory usage high":    "memoryUsage > 90 for 10m clear 85",
+				"Root filesystem full": "diskUsage.root > 90 for 5m clear 85",
+				"Data filesystem full": "diskUsage.data > 90 for 5m clear 85",
+			},
+			SMTP: SMTP{To: []string{}},
+		},
+		Users: map[string]string{},
+	}
+}
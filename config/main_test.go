@@ -0,0 +1,239 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required are the settings without a default, requiredACME adds the ones
+// needed with TLSModeACME
+const (
+	required     = "domain: pi.example.com\n"
+	requiredACME = required + "letsencrypt:\n  email: admin@example.com\n"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad_Example(t *testing.T) {
+	c, err := Load(filepath.Join("..", "config.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Borg) != 2 || c.Borg[0].Name != "documents" {
+		t.Errorf("borg repos are %+v", c.Borg)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := Load(writeConfig(t, requiredACME+"plots:\n  updateInterval: 5s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Domain != "pi.example.com" || c.Plots.UpdateInterval != 5*time.Second {
+		t.Errorf("config file not applied: %+v", c)
+	}
+	// everything else keeps its default
+	if c.Plots.Width != Default().Plots.Width || !reflect.DeepEqual(c.Listen, Default().Listen) {
+		t.Errorf("defaults not kept: %+v", c)
+	}
+}
+
+func TestLoad_Required(t *testing.T) {
+	_, err := Load(writeConfig(t, ""))
+	ve, ok := err.(ValidationError)
+	if !ok || len(ve) != 2 || !strings.HasPrefix(ve[0], "domain:") || !strings.HasPrefix(ve[1], "letsencrypt.email:") {
+		t.Errorf("expected errors for domain and letsencrypt.email, got %v", err)
+	}
+
+	// the email is only needed for the ACME account
+	if _, err := Load(writeConfig(t, required+"tls:\n  mode: local\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoad_UnknownField(t *testing.T) {
+	_, err := Load(writeConfig(t, "domain: pi.example.com\nplots:\n  colour: red\n"))
+	if err == nil {
+		t.Fatal("unknown field should fail")
+	}
+	if !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "colour") {
+		t.Errorf("error should point at the field: %s", err)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load(writeConfig(t, `
+listen:
+  https: "8443"
+  mode: h2c
+  trustedProxies: [proxy.lan]
+tls:
+  mode: selfsigned
+plots:
+  updateInterval: 0s
+network:
+  deny: ["[lo"]
+borg:
+  - name: documents
+alerts:
+  rules:
+    broken: cpuTemp >
+`))
+	ve, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	for _, key := range []string{"listen.https", "listen.mode", "listen.trustedProxies", "tls.mode", "plots.updateInterval", "network.deny", "borg[0]", "alerts.rules"} {
+		found := false
+		for _, e := range ve {
+			found = found || strings.HasPrefix(e, key+":")
+		}
+		if !found {
+			t.Errorf("no error for %s in %s", key, ve)
+		}
+	}
+}
+
+func TestLoad_Challenge(t *testing.T) {
+	tests := []struct {
+		name string
+		le   string
+		key  string
+	}{
+		{"default", "", ""},
+		{"unknown", "challenge: dns", "letsencrypt.challenge"},
+		{"wildcard", "domains: [\"*.example.com\"]", "letsencrypt.domains"},
+		{"dnsProvider", "challenge: dns-01\n  dns:\n    provider: route53", "letsencrypt.dns.provider"},
+		{"rfc2136", "challenge: dns-01\n  dns:\n    provider: rfc2136\n    rfc2136:\n      nameserver: ns:53", ""},
+		{"tsig", "challenge: dns-01\n  dns:\n    provider: rfc2136\n    rfc2136:\n      nameserver: ns:53\n      tsigKey: k", "letsencrypt.dns.rfc2136"},
+		{"exec", "challenge: dns-01\n  domains: [\"*.example.com\"]\n  dns:\n    provider: exec\n    exec: /bin/true", ""},
+		{"staging", "staging: true\n  caDirURL: https://localhost:14000/dir", "letsencrypt.staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, requiredACME+"  "+tt.le+"\n"))
+			if tt.key == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			ve, ok := err.(ValidationError)
+			if !ok || len(ve) != 1 || !strings.HasPrefix(ve[0], tt.key+":") {
+				t.Errorf("expected an error for %s, got %v", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestLoad_LocalCA(t *testing.T) {
+	c, err := Load(writeConfig(t, required+"tls:\n  mode: local\n  local:\n    hosts: [raspi.lan, 192.168.1.10, \"fd00::10\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TLS.Local.CACertFile != Default().TLS.Local.CACertFile || len(c.TLS.Local.Hosts) != 3 {
+		t.Errorf("local CA config is %+v", c.TLS.Local)
+	}
+
+	_, err = Load(writeConfig(t, required+"tls:\n  mode: local\n  local:\n    hosts: [\"http://raspi.lan\"]\n"))
+	if ve, ok := err.(ValidationError); !ok || len(ve) != 1 || !strings.HasPrefix(ve[0], "tls.local.hosts:") {
+		t.Errorf("expected an error for tls.local.hosts, got %v", err)
+	}
+}
+
+func TestLoad_Listen(t *testing.T) {
+	c, err := Load(writeConfig(t, requiredACME+`
+listen:
+  http: "[::]:8080"
+  https: ""
+  mode: http
+  unix: /run/raspi-dash/http.sock
+  trustedProxies: [127.0.0.1, "fd00::/64"]
+  http2: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen.Mode != ListenModeHTTP || c.Listen.HTTPS != "" || len(c.Listen.TrustedProxies) != 2 || c.Listen.HTTP2 {
+		t.Errorf("listen config is %+v", c.Listen)
+	}
+}
+
+func TestLetsEncrypt_DirectoryURL(t *testing.T) {
+	le := Default().LetsEncrypt
+	if le.DirectoryURL() != LetsEncryptProduction {
+		t.Errorf("default CA is %s", le.DirectoryURL())
+	}
+	le.Staging = true
+	if le.DirectoryURL() != LetsEncryptStaging {
+		t.Errorf("staging CA is %s", le.DirectoryURL())
+	}
+	le.Staging = false
+	le.CADirURL = "https://localhost:14000/dir"
+	if le.DirectoryURL() != le.CADirURL {
+		t.Errorf("custom CA is %s", le.DirectoryURL())
+	}
+}
+
+func TestLoad_DisableRule(t *testing.T) {
+	c, err := Load(writeConfig(t, requiredACME+"alerts:\n  rules:\n    Memory usage high: \"\"\n    Swap: swap > 50\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Alerts.Rules["Memory usage high"]; ok {
+		t.Errorf("rule should be disabled")
+	}
+	if c.Alerts.Rules["Swap"] != "swap > 50" || c.Alerts.Rules["CPU temperature high"] == "" {
+		t.Errorf("rules are %v", c.Alerts.Rules)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"RASPI_DASH_LISTEN_HTTPS":           "127.0.0.1:9443",
+		"RASPI_DASH_PLOTS_UPDATE_INTERVAL":  "2s",
+		"RASPI_DASH_PLOTS_WIDTH":            "1024",
+		"RASPI_DASH_NETWORK_DENY":           "lo, docker*",
+		"RASPI_DASH_ALERTS_SMTP_PASSWORD":   "secret",
+		"RASPI_DASH_BORG_PHOTOS_PASSPHRASE": "hunter2",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	c := Default()
+	c.Borg = []BorgRepo{{Name: "photos", ID: "x@y:repo"}}
+	if err := c.applyEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Listen.HTTPS != "127.0.0.1:9443" || c.Plots.UpdateInterval != 2*time.Second || c.Plots.Width != 1024 {
+		t.Errorf("overrides not applied: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Network.Deny, []string{"lo", "docker*"}) {
+		t.Errorf("network.deny is %v", c.Network.Deny)
+	}
+	if c.Alerts.SMTP.Password != "secret" || c.Borg[0].Passphrase != "hunter2" {
+		t.Errorf("secrets not applied: %+v %+v", c.Alerts.SMTP, c.Borg)
+	}
+
+	env["RASPI_DASH_PLOTS_WIDTH"] = "wide"
+	if err := c.applyEnv(lookup); err == nil || !strings.Contains(err.Error(), "RASPI_DASH_PLOTS_WIDTH") {
+		t.Errorf("invalid override should name the variable, got %v", err)
+	}
+}
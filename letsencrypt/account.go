@@ -0,0 +1,370 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/acme"
+	"github.com/go-acme/lego/certcrypto"
+	"github.com/go-acme/lego/lego"
+	"github.com/go-acme/lego/registration"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var (
+	ErrNoAccount = errors.New("no ACME account for this CA")
+)
+
+// Account is what's stored about an ACME account. An account with a key
+// but without a registration is looked up by its key the next time it's
+// used, so an existing account can be recovered by adding its key.
+type Account struct {
+	Email        string                 `json:"email"`
+	Registration *registration.Resource `json:"registration,omitempty"`
+	// Key is the PEM encoded private key of the account
+	Key string `json:"key"`
+}
+
+// accounts are the stored accounts by directory URL of their CA.
+type accounts map[string]*Account
+
+func loadAccounts(p string) (accounts, error) {
+	as := make(accounts)
+
+	buf, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return as, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ACME accounts: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &as); err != nil {
+		return nil, fmt.Errorf("cannot parse ACME accounts in %s: %w", p, err)
+	}
+	return as, nil
+}
+
+func (as accounts) save(p string) error {
+	buf, err := json.MarshalIndent(as, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(p, buf, 0600); err != nil {
+		return fmt.Errorf("cannot save ACME accounts: %w", err)
+	}
+	return nil
+}
+
+// writeFile replaces the file at p with data in a single rename, so
+// readers never see a partially written file.
+func writeFile(p string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func newAccountKey() (crypto.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func parsePrivateKey(s string) (crypto.PrivateKey, error) {
+	if b, _ := pem.Decode([]byte(s)); b == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	return certcrypto.ParsePEMPrivateKey([]byte(s))
+}
+
+// Account returns the account stored for the CA of c.
+func (c Certs) Account() (*Account, error) {
+	as, err := loadAccounts(c.AccountFilePath)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := as[c.CADirectoryURL]
+	if !ok {
+		return nil, ErrNoAccount
+	}
+	return a, nil
+}
+
+// user returns the stored account for the CA of c as a User. Without an
+// account a new key is created and stored before it's registered, so it
+// isn't lost if the registration fails half way.
+func (c Certs) user(userEmail string) (*User, error) {
+	as, err := loadAccounts(c.AccountFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	a, ok := as[c.CADirectoryURL]
+	if !ok {
+		key, err := newAccountKey()
+		if err != nil {
+			return nil, err
+		}
+		a = &Account{Email: userEmail, Key: string(certcrypto.PEMEncode(key))}
+		as[c.CADirectoryURL] = a
+		if err := as.save(c.AccountFilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := parsePrivateKey(a.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &User{Email: a.Email, Registration: a.Registration, key: key}, nil
+}
+
+// saveUser stores usr as the account for the CA of c.
+func (c Certs) saveUser(usr *User) error {
+	as, err := loadAccounts(c.AccountFilePath)
+	if err != nil {
+		return err
+	}
+	as[c.CADirectoryURL] = &Account{
+		Email:        usr.Email,
+		Registration: usr.Registration,
+		Key:          string(certcrypto.PEMEncode(usr.key)),
+	}
+	return as.save(c.AccountFilePath)
+}
+
+func (c Certs) legoConfig(usr *User) *lego.Config {
+	config := lego.NewConfig(usr)
+	config.CADirURL = c.CADirectoryURL
+	config.Certificate.KeyType = certcrypto.RSA2048
+	return config
+}
+
+// client returns a client for the stored account, which is looked up by
+// its key or registered first if necessary.
+func (c Certs) client(userEmail string) (*lego.Client, error) {
+	usr, err := c.user(userEmail)
+	if err != nil {
+		return nil, newError(ErrIO, err)
+	}
+
+	// A client facilitates communication with the CA server.
+	client, err := lego.NewClient(c.legoConfig(usr))
+	if err != nil {
+		return nil, newError(ErrRegistration, fmt.Errorf("cannot create ACME client: %w", err))
+	}
+	if usr.Registration != nil {
+		return client, nil
+	}
+
+	reg, err := client.Registration.ResolveAccountByKey()
+	if err != nil {
+		reg, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return nil, newError(ErrRegistration, fmt.Errorf("cannot register ACME account: %w", err))
+		}
+	}
+	usr.Registration = reg
+
+	if err := c.saveUser(usr); err != nil {
+		return nil, newError(ErrIO, err)
+	}
+	return client, nil
+}
+
+// RecoverAccount looks up the account of the stored key at the CA and
+// stores its registration again, e.g. after the CA moved the account or
+// only the key was kept.
+func (c Certs) RecoverAccount() (*Account, error) {
+	a, err := c.Account()
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(a.Key)
+	if err != nil {
+		return nil, err
+	}
+	usr := &User{Email: a.Email, key: key}
+
+	client, err := lego.NewClient(c.legoConfig(usr))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ACME client: %w", err)
+	}
+	reg, err := client.Registration.ResolveAccountByKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot recover ACME account: %w", err)
+	}
+	usr.Registration = reg
+
+	if err := c.saveUser(usr); err != nil {
+		return nil, err
+	}
+	return c.Account()
+}
+
+// RollAccountKey replaces the key of the stored account with a new one,
+// see RFC 8555 section 7.3.5. The new key is only stored once the CA
+// accepted it.
+func (c Certs) RollAccountKey() error {
+	a, err := c.Account()
+	if err != nil {
+		return err
+	}
+	if a.Registration == nil || a.Registration.URI == "" {
+		return errors.New("account is not registered, recover it first")
+	}
+	oldKey, err := parsePrivateKey(a.Key)
+	if err != nil {
+		return err
+	}
+	newKey, err := newAccountKey()
+	if err != nil {
+		return err
+	}
+
+	usr := &User{Email: a.Email, Registration: a.Registration, key: oldKey}
+	hc := c.legoConfig(usr).HTTPClient
+
+	dir := new(acme.Directory)
+	if err := getJSON(hc, c.CADirectoryURL, dir); err != nil {
+		return fmt.Errorf("cannot get ACME directory: %w", err)
+	}
+	if dir.KeyChangeURL == "" {
+		return errors.New("the CA doesn't support key rollover")
+	}
+
+	body, err := keyChangeRequest(dir.KeyChangeURL, a.Registration.URI, oldKey, newKey, &nonceSource{hc, dir.NewNonceURL})
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Post(dir.KeyChangeURL, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot roll over account key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("cannot roll over account key: %s: %s", resp.Status, msg)
+	}
+
+	usr.key = newKey
+	return c.saveUser(usr)
+}
+
+// keyChangeRequest returns the body of a key change request, the inner JWS
+// signed with newKey wrapped in the outer JWS signed with oldKey.
+func keyChangeRequest(keyChangeURL, accountURL string, oldKey, newKey crypto.PrivateKey, nonces jose.NonceSource) ([]byte, error) {
+	oldJWK := jose.JSONWebKey{Key: publicKey(oldKey)}
+	payload, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{accountURL, oldJWK})
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := sign(jose.JSONWebKey{Key: newKey}, payload, &jose.SignerOptions{
+		EmbedJWK:     true,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": keyChangeURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outer, err := sign(jose.JSONWebKey{Key: oldKey, KeyID: accountURL}, []byte(inner), &jose.SignerOptions{
+		NonceSource:  nonces,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": keyChangeURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(outer), nil
+}
+
+func sign(key jose.JSONWebKey, payload []byte, opts *jose.SignerOptions) (string, error) {
+	alg := jose.ES256
+	if _, ok := key.Key.(*rsa.PrivateKey); ok {
+		alg = jose.RS256
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.FullSerialize(), nil
+}
+
+func publicKey(k crypto.PrivateKey) crypto.PublicKey {
+	switch k := k.(type) {
+	case *ecdsa.PrivateKey:
+		return k.Public()
+	case *rsa.PrivateKey:
+		return k.Public()
+	}
+	return nil
+}
+
+func getJSON(hc *http.Client, url string, v interface{}) error {
+	resp, err := hc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// nonceSource fetches a fresh nonce from the CA for every request.
+type nonceSource struct {
+	hc  *http.Client
+	url string
+}
+
+func (ns *nonceSource) Nonce() (string, error) {
+	resp, err := ns.hc.Head(ns.url)
+	if err != nil {
+		return "", fmt.Errorf("cannot get nonce: %w", err)
+	}
+	resp.Body.Close()
+
+	n := resp.Header.Get("Replay-Nonce")
+	if n == "" {
+		return "", errors.New("cannot get nonce: no Replay-Nonce header")
+	}
+	return n, nil
+}
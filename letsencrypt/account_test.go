@@ -0,0 +1,187 @@
+package letsencrypt
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/acme"
+	"github.com/go-acme/lego/registration"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestCerts_user(t *testing.T) {
+	cs := Certs{
+		CADirectoryURL:  "https://acme.example.org/dir",
+		AccountFilePath: filepath.Join(t.TempDir(), "account.json"),
+	}
+
+	usr, err := cs.user("admin@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.Registration != nil {
+		t.Errorf("new account shouldn't be registered yet")
+	}
+	fi, err := os.Stat(cs.AccountFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("account file has mode %s", fi.Mode())
+	}
+
+	usr.Registration = &registration.Resource{URI: "https://acme.example.org/acct/1"}
+	if err := cs.saveUser(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the account is reused
+	again, err := cs.user("other@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.key.(*ecdsa.PrivateKey).Equal(usr.key) || again.Registration.URI != usr.Registration.URI {
+		t.Errorf("expected the stored account, got %+v", again)
+	}
+
+	// other CAs get their own account
+	staging := cs
+	staging.CADirectoryURL = "https://staging.example.org/dir"
+	other, err := staging.user("admin@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.key.(*ecdsa.PrivateKey).Equal(usr.key) {
+		t.Errorf("expected a new key for another CA")
+	}
+	if a, err := cs.Account(); err != nil || a.Registration.URI != usr.Registration.URI {
+		t.Errorf("account was replaced: %+v %v", a, err)
+	}
+}
+
+// fakeCA implements just enough of ACME to roll over an account key.
+func fakeCA(t *testing.T, accountURL string, oldKey *ecdsa.PrivateKey, status int) *httptest.Server {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+
+	mux.HandleFunc("/dir", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(acme.Directory{
+			NewNonceURL:  srv.URL + "/nonce",
+			KeyChangeURL: srv.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+	})
+	mux.HandleFunc("/key-change", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		// t.Fatal must not be called outside of the test goroutine
+		fail := func(format string, args ...interface{}) {
+			t.Errorf(format, args...)
+			http.Error(w, "malformed", http.StatusBadRequest)
+		}
+
+		outer, err := jose.ParseSigned(string(body))
+		if err != nil {
+			fail("%s", err)
+			return
+		}
+		h := outer.Signatures[0].Protected
+		if h.KeyID != accountURL || h.Nonce != "nonce" || h.ExtraHeaders["url"] != srv.URL+"/key-change" {
+			t.Errorf("unexpected outer header %+v", h)
+		}
+		innerJSON, err := outer.Verify(oldKey.Public())
+		if err != nil {
+			fail("outer JWS isn't signed by the old key: %s", err)
+			return
+		}
+
+		inner, err := jose.ParseSigned(string(innerJSON))
+		if err != nil {
+			fail("%s", err)
+			return
+		}
+		newJWK := inner.Signatures[0].Protected.JSONWebKey
+		if newJWK == nil {
+			fail("inner JWS has no jwk")
+			return
+		}
+		payload, err := inner.Verify(newJWK)
+		if err != nil {
+			fail("inner JWS isn't signed by the new key: %s", err)
+			return
+		}
+
+		var kc struct {
+			Account string          `json:"account"`
+			OldKey  jose.JSONWebKey `json:"oldKey"`
+		}
+		if err := json.Unmarshal(payload, &kc); err != nil {
+			fail("%s", err)
+			return
+		}
+		if kc.Account != accountURL || !kc.OldKey.Key.(*ecdsa.PublicKey).Equal(oldKey.Public()) {
+			t.Errorf("unexpected key change %+v", kc)
+		}
+
+		w.WriteHeader(status)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCerts_RollAccountKey(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		status  int
+		changed bool
+	}{
+		{"accepted", http.StatusOK, true},
+		{"rejected", http.StatusConflict, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := Certs{AccountFilePath: filepath.Join(t.TempDir(), "account.json")}
+			usr := &User{Email: "admin@example.org"}
+
+			key, err := newAccountKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			usr.key = key
+			srv := fakeCA(t, "https://acme.example.org/acct/1", key.(*ecdsa.PrivateKey), tt.status)
+			cs.CADirectoryURL = srv.URL + "/dir"
+			usr.Registration = &registration.Resource{URI: "https://acme.example.org/acct/1"}
+			if err := cs.saveUser(usr); err != nil {
+				t.Fatal(err)
+			}
+
+			err = cs.RollAccountKey()
+			if tt.changed && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.changed && err == nil {
+				t.Fatal("expected an error when the CA rejects the new key")
+			}
+
+			after, err := cs.user(usr.Email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := !after.key.(*ecdsa.PrivateKey).Equal(key); changed != tt.changed {
+				t.Errorf("expected key changed to be %v", tt.changed)
+			}
+			if after.Registration.URI != usr.Registration.URI {
+				t.Errorf("registration lost: %+v", after.Registration)
+			}
+		})
+	}
+}
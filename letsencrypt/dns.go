@@ -0,0 +1,33 @@
+package letsencrypt
+
+import (
+	"github.com/go-acme/lego/challenge"
+	"github.com/go-acme/lego/providers/dns/exec"
+	"github.com/go-acme/lego/providers/dns/rfc2136"
+)
+
+// NewRFC2136Provider publishes the records of the DNS-01 challenge with
+// dynamic updates sent to nameserver. The TSIG key and secret may be empty
+// if the nameserver doesn't require authentication, algorithm defaults to
+// hmac-md5.
+func NewRFC2136Provider(nameserver, key, secret, algorithm string) (challenge.Provider, error) {
+	c := rfc2136.NewDefaultConfig()
+	c.Nameserver = nameserver
+	c.TSIGKey = key
+	c.TSIGSecret = secret
+	if algorithm != "" {
+		c.TSIGAlgorithm = algorithm
+	}
+
+	return rfc2136.NewDNSProviderConfig(c)
+}
+
+// NewExecProvider publishes the records of the DNS-01 challenge by running
+// program as "program present <fqdn> <value>" and removes them with
+// "program cleanup <fqdn> <value>".
+func NewExecProvider(program string) (challenge.Provider, error) {
+	c := exec.NewDefaultConfig()
+	c.Program = program
+
+	return exec.NewDNSProviderConfig(c)
+}
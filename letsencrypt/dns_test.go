@@ -0,0 +1,40 @@
+package letsencrypt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/challenge/dns01"
+)
+
+func TestNewExecProvider(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "calls")
+	hook := filepath.Join(dir, "hook")
+	script := "#!/bin/sh\necho \"$@\" >> " + out + "\n"
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewExecProvider(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Present("example.org", "token", "keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp("example.org", "token", "keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fqdn, value := dns01.GetRecord("example.org", "keyAuth")
+	want := "present " + fqdn + " " + value + "\ncleanup " + fqdn + " " + value + "\n"
+	if string(buf) != want {
+		t.Errorf("expected hook calls\n%s\ngot\n%s", want, buf)
+	}
+}
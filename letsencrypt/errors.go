@@ -0,0 +1,47 @@
+package letsencrypt
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	rateLimitedProblem = "urn:ietf:params:acme:error:rateLimited"
+)
+
+var (
+	ErrRegistration = errors.New("ACME account setup failed")
+	ErrChallenge    = errors.New("ACME challenge failed")
+	ErrRateLimited  = errors.New("rate limited by the CA")
+	ErrIO           = errors.New("cannot read or write files")
+)
+
+// Error is returned when a certificate can't be obtained. Kind is one of
+// the Err* values above and can be checked with errors.Is.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// newError wraps err as kind, unless the CA said it's rate limiting us,
+// which takes precedence.
+func newError(kind, err error) *Error {
+	// lego doesn't keep the problem details of failed authorizations, so
+	// the error message is all there is
+	if strings.Contains(err.Error(), rateLimitedProblem) {
+		kind = ErrRateLimited
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
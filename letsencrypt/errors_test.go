@@ -0,0 +1,46 @@
+package letsencrypt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-acme/lego/acme"
+)
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name string
+		kind error
+		err  error
+		want error
+	}{
+		{"challenge", ErrChallenge, errors.New("acme: error: 403 :: urn:ietf:params:acme:error:unauthorized"), ErrChallenge},
+		{"registration", ErrRegistration, errors.New("cannot register ACME account"), ErrRegistration},
+		{"io", ErrIO, fmt.Errorf("cannot save key: %w", errors.New("disk full")), ErrIO},
+		{"rate limited", ErrChallenge, &acme.ProblemDetails{
+			HTTPStatus: 429,
+			Type:       rateLimitedProblem,
+			Detail:     "too many certificates already issued",
+		}, ErrRateLimited},
+		{"rate limited during registration", ErrRegistration, fmt.Errorf("cannot register ACME account: %w", acme.ProblemDetails{
+			HTTPStatus: 429,
+			Type:       rateLimitedProblem,
+		}), ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := error(newError(tt.kind, tt.err))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v to wrap %v", err, tt.err)
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Kind != tt.want {
+				t.Errorf("expected an *Error of kind %v, got %#v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package letsencrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/certcrypto"
+	"github.com/go-acme/lego/certificate"
+)
+
+const (
+	localCAValidity   = 10 * 365 * 24 * time.Hour
+	localLeafValidity = 90 * 24 * time.Hour
+)
+
+// LocalCA issues certificates for installations that can't reach an ACME
+// CA, e.g. on a LAN without public DNS. Clients have to trust its
+// certificate, which is served by ServeHTTP.
+type LocalCA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadOrCreateLocalCA reads the CA from certPath and keyPath, or creates a
+// new one named after name if neither file exists.
+func LoadOrCreateLocalCA(certPath, keyPath, name string) (*LocalCA, error) {
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		return createLocalCA(certPath, keyPath, name)
+	}
+	if certErr != nil {
+		return nil, fmt.Errorf("cannot read local CA: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, fmt.Errorf("cannot read local CA: %w", keyErr)
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local CA certificate: %w", err)
+	}
+	key, err := parsePrivateKey(string(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid local CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("invalid local CA key")
+	}
+
+	return &LocalCA{cert: cert, certPEM: certPEM, key: signer}, nil
+}
+
+func createLocalCA(certPath, keyPath, name string) (*LocalCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"raspi-dash"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der))
+
+	// the key is written first, a certificate without its key is useless
+	if err := writeFile(keyPath, certcrypto.PEMEncode(key), 0600); err != nil {
+		return nil, fmt.Errorf("cannot save local CA: %w", err)
+	}
+	if err := writeFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("cannot save local CA: %w", err)
+	}
+
+	return &LocalCA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// selfSignedCertificate returns a certificate for names and ips that's signed with its
+// own key.
+func selfSignedCertificate(names []string, ips []net.IP) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	cn := ""
+	if len(names) > 0 {
+		cn = names[0]
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"raspi-dash"}},
+		DNSNames:     names,
+		IPAddresses:  ips,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Certificate returns the PEM encoded certificate of the CA.
+func (ca *LocalCA) Certificate() []byte {
+	return ca.certPEM
+}
+
+// Issue returns a new certificate for the names and IP addresses, bundled
+// with the certificate of the CA.
+func (ca *LocalCA) Issue(names []string, ips []net.IP) (*certificate.Resource, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(localLeafValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	cn := ""
+	if len(names) > 0 {
+		cn = names[0]
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     names,
+		IPAddresses:  ips,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot issue certificate: %w", err)
+	}
+
+	crt := certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der))
+	return &certificate.Resource{
+		Domain:      cn,
+		Certificate: append(crt, ca.certPEM...),
+		PrivateKey:  certcrypto.PEMEncode(key),
+	}, nil
+}
+
+// ServeHTTP lets clients download the certificate of the CA to install it
+// as trusted.
+func (ca *LocalCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="ca.crt"`)
+	w.Write(ca.certPEM)
+}
+
+// NewLocalManager returns a Manager that issues the certificates for
+// cs.Domains and cs.IPAddresses with ca instead of requesting them through
+// ACME.
+func NewLocalManager(cs Certs, ca *LocalCA) *Manager {
+	m := NewManager(cs, "")
+	m.local = true
+	m.obtain = func() (*certificate.Resource, error) {
+		return ca.Issue(m.Certs.Domains, m.Certs.IPAddresses)
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+package letsencrypt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+
+	ca, err := LoadOrCreateLocalCA(certPath, keyPath, "test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.cert.IsCA || ca.cert.Subject.CommonName != "test CA" {
+		t.Errorf("unexpected CA certificate %+v", ca.cert.Subject)
+	}
+	fi, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("CA key has mode %s", fi.Mode())
+	}
+
+	// the CA is kept across restarts
+	again, err := LoadOrCreateLocalCA(certPath, keyPath, "other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.cert.Equal(ca.cert) {
+		t.Errorf("expected the stored CA to be loaded")
+	}
+
+	// a CA that's only half there isn't replaced
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateLocalCA(certPath, keyPath, "test CA"); err == nil {
+		t.Errorf("expected an error without the CA key")
+	}
+}
+
+func TestNewLocalManager(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateLocalCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewLocalManager(Certs{
+		Domains:        []string{"raspi.lan"},
+		IPAddresses:    []net.IP{net.ParseIP("192.168.1.10")},
+		CertFilePath:   filepath.Join(dir, "cert.pem"),
+		PrivateKeyPath: filepath.Join(dir, "key.pem"),
+	}, ca)
+	if err := m.Renew(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Status().Local {
+		t.Errorf("status should show the local CA")
+	}
+
+	c, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "raspi.lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clients trusting ca.crt accept the certificate for the name and the
+	// address
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca.Certificate()) {
+		t.Fatal("cannot parse CA certificate")
+	}
+	for _, host := range []string{"raspi.lan", "192.168.1.10"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("certificate not valid for %s: %s", host, err)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.org", Roots: roots}); err == nil {
+		t.Errorf("certificate shouldn't be valid for example.org")
+	}
+
+	// a new address is added right away
+	m.Certs.IPAddresses = append(m.Certs.IPAddresses, net.ParseIP("fd00::10"))
+	if !m.due(time.Now()) {
+		t.Errorf("expected renewal to be due after adding an address")
+	}
+}
+
+func TestLocalCA_ServeHTTP(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateLocalCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ca.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ca.crt", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-x509-ca-cert" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if w.Body.String() != string(ca.Certificate()) {
+		t.Errorf("expected the CA certificate, got %q", w.Body.String())
+	}
+}
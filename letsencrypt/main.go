@@ -2,29 +2,39 @@ package letsencrypt
 
 import (
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"errors"
-	"log"
-	"os"
+	"fmt"
+	"net"
 
-	"github.com/go-acme/lego/certcrypto"
 	"github.com/go-acme/lego/certificate"
-	"github.com/go-acme/lego/challenge/tlsalpn01"
+	"github.com/go-acme/lego/challenge"
+	"github.com/go-acme/lego/challenge/dns01"
 	"github.com/go-acme/lego/lego"
 	"github.com/go-acme/lego/registration"
 )
 
-var (
-	ErrCertFilesAlreadyExist = errors.New("cert files already exist on disk")
+const (
+	ChallengeTLSALPN = "tls-alpn-01"
+	ChallengeHTTP    = "http-01"
+	ChallengeDNS     = "dns-01"
 )
 
 type Certs struct {
-	Domains        []string
+	Domains []string
+	// IPAddresses are only added to the certificates of a LocalCA
+	IPAddresses    []net.IP
 	CertFilePath   string
 	PrivateKeyPath string
 	CADirectoryURL string
+	// AccountFilePath is where the ACME account is kept, one per CA
+	AccountFilePath string
+	// Challenge is one of the Challenge* constants, ChallengeTLSALPN if
+	// empty
+	Challenge string
+	// DNSProvider publishes the records of ChallengeDNS, see
+	// NewRFC2136Provider and NewExecProvider
+	DNSProvider challenge.Provider
+	// DNSResolvers are used to check the records have propagated
+	DNSResolvers []string
 }
 
 type User struct {
@@ -43,81 +53,32 @@ func (u *User) GetPrivateKey() crypto.PrivateKey {
 	return u.key
 }
 
-func (c Certs) FilesExist() (bool, error) {
-	_, err := os.Stat(c.CertFilePath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return false, nil
-		}
-
-		return true, err
-	}
-
-	_, err = os.Stat(c.PrivateKeyPath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return false, nil
-		}
-		return true, err
+// setProvider makes client solve c.Challenge with p.
+func (c Certs) setProvider(client *lego.Client, p challenge.Provider) error {
+	switch c.Challenge {
+	case ChallengeTLSALPN, "":
+		return client.Challenge.SetTLSALPN01Provider(p)
+	case ChallengeHTTP:
+		return client.Challenge.SetHTTP01Provider(p)
+	case ChallengeDNS:
+		return client.Challenge.SetDNS01Provider(p,
+			dns01.CondOption(len(c.DNSResolvers) > 0, dns01.AddRecursiveNameservers(c.DNSResolvers)))
 	}
-
-	return true, nil
-
+	return fmt.Errorf("unknown challenge %q", c.Challenge)
 }
 
-func (c Certs) RequestCerts(userEmail string) error {
-	var (
-		exist bool
-		err   error
-	)
-	if exist, err = c.FilesExist(); err != nil {
-		return err
-	}
-	if exist {
-		return ErrCertFilesAlreadyExist
-	}
-
-	// Create a user. New accounts need an email and private key to start.
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	usr := &User{
-		Email: userEmail,
-		key:   privateKey,
-	}
-
-	config := lego.NewConfig(usr)
-
-	config.CADirURL = c.CADirectoryURL
-	config.Certificate.KeyType = certcrypto.RSA2048
-
-	// A client facilitates communication with the CA server.
-	client, err := lego.NewClient(config)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Start listeners for ACME challenge
-	// err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "8080"))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	cpSrv := tlsalpn01.NewProviderServer("", "8443")
-	err = client.Challenge.SetTLSALPN01Provider(cpSrv)
+// obtain requests a certificate for all domains with the stored account,
+// solving c.Challenge with p.
+func (c Certs) obtain(userEmail string, p challenge.Provider) (*certificate.Resource, error) {
+	client, err := c.client(userEmail)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer cpSrv.CleanUp("", "", "")
 
-	// New users will need to register
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	err = c.setProvider(client, p)
 	if err != nil {
-		log.Fatal(err)
+		return nil, newError(ErrChallenge, err)
 	}
-	usr.Registration = reg
 
 	request := certificate.ObtainRequest{
 		Domains: c.Domains,
@@ -125,29 +86,20 @@ func (c Certs) RequestCerts(userEmail string) error {
 	}
 	certificates, err := client.Certificate.Obtain(request)
 	if err != nil {
-		log.Fatal(err)
+		return nil, newError(ErrChallenge, fmt.Errorf("cannot obtain certificate: %w", err))
 	}
 
-	crtF, err := os.OpenFile(c.CertFilePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
-	if err != nil {
-		log.Fatalln(err.Error())
-	}
-	defer crtF.Close()
-	_, err = crtF.Write(certificates.Certificate)
-	if err != nil {
-		log.Fatalln(err.Error())
-	}
+	return certificates, nil
+}
 
-	pkF, err := os.OpenFile(c.PrivateKeyPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
-	if err != nil {
-		log.Fatalln(err.Error())
+// save writes the certificate and key of res to their files.
+func (c Certs) save(res *certificate.Resource) error {
+	// the key is written first, a certificate without its key is useless
+	if err := writeFile(c.PrivateKeyPath, res.PrivateKey, 0600); err != nil {
+		return newError(ErrIO, fmt.Errorf("cannot save key: %w", err))
 	}
-	defer pkF.Close()
-
-	_, err = pkF.Write(certificates.PrivateKey)
-	if err != nil {
-		log.Fatalln(err.Error())
+	if err := writeFile(c.CertFilePath, res.Certificate, 0600); err != nil {
+		return newError(ErrIO, fmt.Errorf("cannot save certificate: %w", err))
 	}
-
 	return nil
 }
@@ -0,0 +1,389 @@
+package letsencrypt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-acme/lego/certificate"
+	"github.com/go-acme/lego/challenge"
+	"github.com/go-acme/lego/challenge/http01"
+	"github.com/go-acme/lego/challenge/tlsalpn01"
+)
+
+const (
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	checkInterval = 12 * time.Hour
+	// rateLimitWait is the least time to wait once the CA rate limits us,
+	// retrying earlier only counts against the limit again
+	rateLimitWait = 3 * time.Hour
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificate loaded yet")
+)
+
+// Status describes the certificate currently served by a Manager.
+type Status struct {
+	Domains     []string
+	NotAfter    time.Time
+	NextRenewal time.Time
+	// LastError is the error of the last failed renewal, empty after a
+	// successful one.
+	LastError string
+	// Local is set if the certificate is issued by a LocalCA
+	Local bool
+	// SelfSigned is set while a self-signed certificate is served because
+	// there's no other one yet
+	SelfSigned bool
+}
+
+// Manager keeps the certificate in the files of Certs valid while the
+// server is running. It serves the current certificate through
+// GetCertificate and renews it once less than RenewBefore remain. The
+// TLS-ALPN-01 challenge is answered through TLSConfig by the HTTPS server
+// listening on port 443, the HTTP-01 challenge through HTTPHandler by the
+// HTTP server listening on port 80.
+type Manager struct {
+	Certs       Certs
+	Email       string
+	RenewBefore time.Duration
+	// NewBackOff returns the backoff policy for retrying failed renewals
+	NewBackOff func() backoff.BackOff
+
+	// obtain requests a new certificate, replaced in tests
+	obtain func() (*certificate.Resource, error)
+	local  bool
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	leaf       *x509.Certificate
+	selfSigned *tls.Certificate
+	lastErr    error
+	challenges map[string]*tls.Certificate
+	tokens     map[string]string
+}
+
+func NewManager(cs Certs, email string) *Manager {
+	m := &Manager{
+		Certs:       cs,
+		Email:       email,
+		RenewBefore: DefaultRenewBefore,
+		NewBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.InitialInterval = 5 * time.Minute
+			b.MaxInterval = 6 * time.Hour
+			b.MaxElapsedTime = 0
+			return b
+		},
+		challenges: make(map[string]*tls.Certificate),
+		tokens:     make(map[string]string),
+	}
+	m.obtain = func() (*certificate.Resource, error) {
+		return m.Certs.obtain(m.Email, m.provider())
+	}
+	return m
+}
+
+// provider returns what answers the challenge of m.Certs.
+func (m *Manager) provider() challenge.Provider {
+	switch m.Certs.Challenge {
+	case ChallengeHTTP:
+		return httpSolver{m}
+	case ChallengeDNS:
+		return m.Certs.DNSProvider
+	}
+	return tlsALPNSolver{m}
+}
+
+// Load reads the certificate and key from disk and starts serving them.
+func (m *Manager) Load() error {
+	crt, err := os.ReadFile(m.Certs.CertFilePath)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(m.Certs.PrivateKeyPath)
+	if err != nil {
+		return err
+	}
+	return m.use(crt, key)
+}
+
+// UseSelfSigned serves a self-signed certificate for the domains and
+// addresses of m.Certs until the first renewal succeeds, so the server can
+// start without a certificate. It's never written to disk.
+func (m *Manager) UseSelfSigned() error {
+	c, err := selfSignedCertificate(m.Certs.Domains, m.Certs.IPAddresses)
+	if err != nil {
+		return fmt.Errorf("cannot create self-signed certificate: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.selfSigned = c
+	return nil
+}
+
+func (m *Manager) use(crt, key []byte) error {
+	c, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cert = &c
+	m.leaf = leaf
+	return nil
+}
+
+// NextRenewal returns when the certificate is due for renewal. Without a
+// certificate it's due immediately.
+func (m *Manager) NextRenewal() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.leaf == nil {
+		return time.Time{}
+	}
+	return m.leaf.NotAfter.Add(-m.RenewBefore)
+}
+
+// due reports whether the certificate has to be renewed at now, because
+// it's about to expire or doesn't cover all domains and addresses anymore.
+func (m *Manager) due(now time.Time) bool {
+	m.mu.RLock()
+	leaf := m.leaf
+	m.mu.RUnlock()
+
+	if leaf == nil || !now.Before(m.NextRenewal()) {
+		return true
+	}
+
+	names := make(map[string]bool)
+	for _, n := range leaf.DNSNames {
+		names[strings.ToLower(n)] = true
+	}
+	for _, d := range m.Certs.Domains {
+		if !names[strings.ToLower(d)] {
+			return true
+		}
+	}
+	for _, ip := range m.Certs.IPAddresses {
+		found := false
+		for _, cip := range leaf.IPAddresses {
+			found = found || cip.Equal(ip)
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// Renew requests a new certificate if the current one is due at now, writes
+// it to disk and starts serving it.
+func (m *Manager) Renew(now time.Time) error {
+	if !m.due(now) {
+		m.mu.Lock()
+		m.lastErr = nil
+		m.mu.Unlock()
+		return nil
+	}
+
+	err := m.renew()
+
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+
+	return err
+}
+
+func (m *Manager) renew() error {
+	res, err := m.obtain()
+	if err != nil {
+		return err
+	}
+	// it's only served once it's saved, otherwise it would be lost on the
+	// next restart without being requested again
+	if _, err := tls.X509KeyPair(res.Certificate, res.PrivateKey); err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	if err := m.Certs.save(res); err != nil {
+		return err
+	}
+	if err := m.use(res.Certificate, res.PrivateKey); err != nil {
+		return err
+	}
+
+	log.Printf("renewed certificate for %v, next renewal at %s", m.Certs.Domains, m.NextRenewal().Format(time.RFC3339))
+	return nil
+}
+
+// Run renews the certificate whenever it's due until ctx is done. Failed
+// renewals are retried with m.NewBackOff, but not before rateLimitWait once
+// the CA rate limits us.
+func (m *Manager) Run(ctx context.Context) {
+	b := m.NewBackOff()
+	for {
+		wait := checkInterval
+		if err := m.Renew(time.Now()); err != nil {
+			wait = b.NextBackOff()
+			if wait == backoff.Stop {
+				wait = checkInterval
+			}
+			if errors.Is(err, ErrRateLimited) && wait < rateLimitWait {
+				wait = rateLimitWait
+			}
+			log.Printf("cannot renew certificate, retrying in %s: %s", wait, err.Error())
+		} else {
+			b.Reset()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Status returns the details of the certificate that is being served.
+func (m *Manager) Status() Status {
+	next := m.NextRenewal()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := Status{Domains: m.Certs.Domains, NextRenewal: next, Local: m.local}
+	if m.leaf != nil {
+		s.NotAfter = m.leaf.NotAfter
+	} else {
+		s.SelfSigned = m.selfSigned != nil
+	}
+	if m.lastErr != nil {
+		s.LastError = m.lastErr.Error()
+	}
+	return s
+}
+
+// GetCertificate returns the current certificate, or the challenge
+// certificate if the CA is validating a domain. Without a certificate it
+// returns the self-signed one of UseSelfSigned.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, p := range hello.SupportedProtos {
+		if p != tlsalpn01.ACMETLS1Protocol {
+			continue
+		}
+		if c, ok := m.challenges[hello.ServerName]; ok {
+			return c, nil
+		}
+		return nil, fmt.Errorf("no challenge pending for %q", hello.ServerName)
+	}
+
+	if m.cert != nil {
+		return m.cert, nil
+	}
+	if m.selfSigned != nil {
+		return m.selfSigned, nil
+	}
+	return nil, ErrNoCertificate
+}
+
+// TLSConfig returns the config for a server using the certificates of m.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", tlsalpn01.ACMETLS1Protocol},
+	}
+}
+
+// HTTPHandler answers the HTTP-01 challenge and passes every other request
+// on to fallback.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, http01.ChallengePath("")) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.RLock()
+		keyAuth, ok := m.tokens[strings.TrimPrefix(r.URL.Path, http01.ChallengePath(""))]
+		m.mu.RUnlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+// tlsALPNSolver makes the challenge certificates available through
+// GetCertificate.
+type tlsALPNSolver struct {
+	m *Manager
+}
+
+func (s tlsALPNSolver) Present(domain, token, keyAuth string) error {
+	c, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.m.challenges[domain] = c
+	return nil
+}
+
+func (s tlsALPNSolver) CleanUp(domain, token, keyAuth string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.challenges, domain)
+	return nil
+}
+
+// httpSolver makes the key authorizations available through HTTPHandler.
+type httpSolver struct {
+	m *Manager
+}
+
+func (s httpSolver) Present(domain, token, keyAuth string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.m.tokens[token] = keyAuth
+	return nil
+}
+
+func (s httpSolver) CleanUp(domain, token, keyAuth string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.tokens, token)
+	return nil
+}
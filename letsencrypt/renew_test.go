@@ -0,0 +1,421 @@
+package letsencrypt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-acme/lego/certcrypto"
+	"github.com/go-acme/lego/certificate"
+	"github.com/go-acme/lego/challenge/tlsalpn01"
+)
+
+var (
+	acmeIdentifierOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+)
+
+// selfSigned returns a certificate for domain that expires at notAfter.
+func selfSigned(t *testing.T, domain string, notAfter time.Time) *certificate.Resource {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(notAfter.Unix()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &certificate.Resource{
+		Domain:      domain,
+		Certificate: certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der)),
+		PrivateKey:  certcrypto.PEMEncode(key),
+	}
+}
+
+func testManager(t *testing.T) *Manager {
+	dir := t.TempDir()
+	return NewManager(Certs{
+		Domains:        []string{"example.org"},
+		CertFilePath:   filepath.Join(dir, "cert.pem"),
+		PrivateKeyPath: filepath.Join(dir, "key.pem"),
+	}, "admin@example.org")
+}
+
+func servedNotAfter(t *testing.T, m *Manager) time.Time {
+	t.Helper()
+
+	c, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.NotAfter.UTC().Truncate(time.Second)
+}
+
+func TestManager_Renew(t *testing.T) {
+	m := testManager(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	obtained := 0
+	expiry := now.Add(90 * 24 * time.Hour)
+	m.obtain = func() (*certificate.Resource, error) {
+		obtained++
+		return selfSigned(t, "example.org", expiry), nil
+	}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"}); err != ErrNoCertificate {
+		t.Fatalf("expected %v without a certificate, got %v", ErrNoCertificate, err)
+	}
+
+	// a missing certificate is requested right away
+	if err := m.Renew(now); err != nil {
+		t.Fatal(err)
+	}
+	if obtained != 1 {
+		t.Fatalf("expected 1 certificate to be obtained, got %d", obtained)
+	}
+	if got := servedNotAfter(t, m); !got.Equal(expiry) {
+		t.Errorf("expected certificate expiring at %s to be served, got %s", expiry, got)
+	}
+	if got, want := m.NextRenewal(), expiry.Add(-DefaultRenewBefore); !got.Equal(want) {
+		t.Errorf("expected next renewal at %s, got %s", want, got)
+	}
+
+	// a restart picks up the saved certificate
+	m2 := NewManager(m.Certs, m.Email)
+	if err := m2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedNotAfter(t, m2); !got.Equal(expiry) {
+		t.Errorf("expected saved certificate expiring at %s, got %s", expiry, got)
+	}
+
+	// nothing happens before the renewal is due
+	if err := m.Renew(now.Add(59 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if obtained != 1 {
+		t.Fatalf("expected no renewal 31 days before expiry, got %d certificates", obtained)
+	}
+
+	// the renewed certificate is served without a restart
+	old := expiry
+	expiry = expiry.Add(60 * 24 * time.Hour)
+	if err := m.Renew(now.Add(61 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if obtained != 2 {
+		t.Fatalf("expected a renewal 29 days before expiry, got %d certificates", obtained)
+	}
+	if got := servedNotAfter(t, m); !got.Equal(expiry) {
+		t.Errorf("expected renewed certificate expiring at %s, got %s (old one %s)", expiry, got, old)
+	}
+	if s := m.Status(); s.LastError != "" || !s.NotAfter.Equal(expiry) {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestManager_UseSelfSigned(t *testing.T) {
+	m := testManager(t)
+	if err := m.UseSelfSigned(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the self-signed certificate is served until there's a real one
+	c, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("example.org"); err != nil {
+		t.Error(err)
+	}
+	if s := m.Status(); !s.SelfSigned || !s.NotAfter.IsZero() {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if !m.due(time.Now()) {
+		t.Errorf("expected renewal to be due with a self-signed certificate")
+	}
+	if _, err := os.Stat(m.Certs.CertFilePath); err == nil {
+		t.Errorf("self-signed certificate shouldn't be saved")
+	}
+
+	expiry := time.Now().UTC().Truncate(time.Second).Add(90 * 24 * time.Hour)
+	m.obtain = func() (*certificate.Resource, error) {
+		return selfSigned(t, "example.org", expiry), nil
+	}
+	if err := m.Renew(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedNotAfter(t, m); !got.Equal(expiry) {
+		t.Errorf("expected obtained certificate expiring at %s, got %s", expiry, got)
+	}
+	if m.Status().SelfSigned {
+		t.Errorf("status still shows the self-signed certificate")
+	}
+}
+
+func TestManager_RunRetries(t *testing.T) {
+	m := testManager(t)
+	m.NewBackOff = func() backoff.BackOff {
+		return backoff.NewConstantBackOff(time.Millisecond)
+	}
+
+	attempts := make(chan int)
+	n := 0
+	m.obtain = func() (*certificate.Resource, error) {
+		n++
+		attempts <- n
+		if n < 3 {
+			return nil, newError(ErrChallenge, errors.New("connection refused"))
+		}
+		return selfSigned(t, "example.org", time.Now().Add(90*24*time.Hour)), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case got := <-attempts:
+			if got != i {
+				t.Fatalf("expected attempt %d, got %d", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no attempt %d", i)
+		}
+	}
+	cancel()
+	<-done
+
+	if s := m.Status(); s.LastError != "" || s.NotAfter.IsZero() {
+		t.Errorf("expected the last retry to succeed, got %+v", s)
+	}
+	if _, err := os.Stat(m.Certs.CertFilePath); err != nil {
+		t.Errorf("expected the certificate to be saved: %s", err)
+	}
+}
+
+func TestManager_RenewSaveFails(t *testing.T) {
+	m := testManager(t)
+	dir := filepath.Dir(m.Certs.CertFilePath)
+	m.Certs.CertFilePath = filepath.Join(dir, "missing", "cert.pem")
+	now := time.Now()
+	m.obtain = func() (*certificate.Resource, error) {
+		return selfSigned(t, "example.org", now.Add(90*24*time.Hour)), nil
+	}
+
+	// a certificate that can't be saved isn't served and is requested again
+	if err := m.Renew(now); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected %v, got %v", ErrIO, err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"}); err != ErrNoCertificate {
+		t.Errorf("expected %v, got %v", ErrNoCertificate, err)
+	}
+	if !m.due(now) {
+		t.Errorf("expected renewal to still be due")
+	}
+
+	m.Certs.CertFilePath = filepath.Join(dir, "cert.pem")
+	if err := m.Renew(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// an old failure isn't reported once nothing is due anymore
+	m.lastErr = errors.New("rate limited")
+	if err := m.Renew(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Status(); s.LastError != "" {
+		t.Errorf("expected the last error to be cleared, got %q", s.LastError)
+	}
+}
+
+func TestManager_RenewDomains(t *testing.T) {
+	m := testManager(t)
+	now := time.Now()
+
+	m.obtain = func() (*certificate.Resource, error) {
+		return selfSigned(t, "example.org", now.Add(90*24*time.Hour)), nil
+	}
+	if err := m.Renew(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// a certificate that doesn't cover a new domain is replaced at once
+	m.Certs.Domains = append(m.Certs.Domains, "www.example.org")
+	if !m.due(now) {
+		t.Error("expected renewal to be due after adding a domain")
+	}
+}
+
+func TestManager_GetCertificateChallenge(t *testing.T) {
+	m := testManager(t)
+	solver := tlsALPNSolver{m}
+	hello := &tls.ClientHelloInfo{
+		ServerName:      "example.org",
+		SupportedProtos: []string{tlsalpn01.ACMETLS1Protocol},
+	}
+
+	if _, err := m.GetCertificate(hello); err == nil {
+		t.Fatal("expected an error without a pending challenge")
+	}
+
+	if err := solver.Present("example.org", "token", "keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range leaf.Extensions {
+		found = found || e.Id.Equal(acmeIdentifierOID)
+	}
+	if !found {
+		t.Errorf("expected the challenge certificate to be served")
+	}
+
+	if err := solver.CleanUp("example.org", "token", "keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCertificate(hello); err == nil {
+		t.Fatal("expected an error after the challenge was cleaned up")
+	}
+}
+
+func TestManager_RenewWildcard(t *testing.T) {
+	m := testManager(t)
+	m.Certs.Domains = []string{"example.org", "*.example.org"}
+	now := time.Now()
+
+	m.obtain = func() (*certificate.Resource, error) {
+		return selfSigned(t, "*.example.org", now.Add(90*24*time.Hour)), nil
+	}
+	if err := m.Renew(now); err != nil {
+		t.Fatal(err)
+	}
+	if !m.due(now) {
+		t.Error("expected renewal to be due without example.org in the certificate")
+	}
+
+	m.Certs.Domains = []string{"*.EXAMPLE.org"}
+	if m.due(now) {
+		t.Error("expected wildcard certificate to cover *.EXAMPLE.org")
+	}
+}
+
+func TestManager_HTTPHandler(t *testing.T) {
+	m := testManager(t)
+	m.Certs.Challenge = ChallengeHTTP
+	h := m.HTTPHandler(http.RedirectHandler("https://example.org", http.StatusMovedPermanently))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/.well-known/acme-challenge/token"); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d without a pending challenge, got %d", http.StatusNotFound, w.Code)
+	}
+
+	p := m.provider()
+	if err := p.Present("example.org", "token", "token.keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/.well-known/acme-challenge/token"); w.Code != http.StatusOK || w.Body.String() != "token.keyAuth" {
+		t.Errorf("expected the key authorization, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/index.html"); w.Code != http.StatusMovedPermanently {
+		t.Errorf("expected other requests to be redirected, got %d", w.Code)
+	}
+
+	if err := p.CleanUp("example.org", "token", "token.keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/.well-known/acme-challenge/token"); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d after clean up, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestManager_Pebble requests a certificate from a local ACME server such
+// as Pebble, e.g.
+//
+//	PEBBLE_VA_ALWAYS_VALID=1 pebble -config test/config/pebble-config.json
+//	RASPI_DASH_TEST_ACME_DIR=https://localhost:14000/dir \
+//	LEGO_CA_CERTIFICATES=test/certs/pebble.minica.pem go test ./letsencrypt
+func TestManager_Pebble(t *testing.T) {
+	dirURL := os.Getenv("RASPI_DASH_TEST_ACME_DIR")
+	if dirURL == "" {
+		t.Skip("RASPI_DASH_TEST_ACME_DIR is not set")
+	}
+
+	m := testManager(t)
+	m.Certs.CADirectoryURL = dirURL
+	m.Certs.AccountFilePath = filepath.Join(t.TempDir(), "account.json")
+
+	if err := m.Renew(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	a, err := m.Certs.Account()
+	if err != nil || a.Registration == nil {
+		t.Fatalf("account not stored: %+v %v", a, err)
+	}
+	if err := m.Certs.RollAccountKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Certs.RecoverAccount(); err != nil {
+		t.Fatal(err)
+	}
+	// the next certificate is requested with the same account
+	if err := m.renew(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := m.Certs.Account(); err != nil || b.Registration.URI != a.Registration.URI {
+		t.Errorf("expected account %s to be reused, got %+v %v", a.Registration.URI, b, err)
+	}
+	if s := m.Status(); s.NotAfter.IsZero() || s.LastError != "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if err := NewManager(m.Certs, m.Email).Load(); err != nil {
+		t.Errorf("cannot load saved certificate: %s", err.Error())
+	}
+}
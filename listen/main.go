@@ -0,0 +1,144 @@
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd
+	listenFDsStart = 3
+
+	NameHTTP  = "http"
+	NameHTTPS = "https"
+	NameUnix  = "unix"
+)
+
+// Listeners are what the servers listen on, nil for the ones that aren't
+// configured.
+type Listeners struct {
+	HTTP  net.Listener
+	HTTPS net.Listener
+	Unix  net.Listener
+}
+
+// Open returns the listeners for the addresses of the HTTP and HTTPS
+// servers and the path of the unix socket. Sockets passed by systemd socket
+// activation take precedence, they are told apart by their
+// FileDescriptorName, which is one of http, https and unix. Empty addresses
+// aren't listened on.
+func Open(httpAddr, httpsAddr, unixPath string) (*Listeners, error) {
+	ls := new(Listeners)
+
+	activated, err := systemd(os.Getenv, os.Getpid(), listenFDsStart)
+	if err != nil {
+		return nil, err
+	}
+	for name, l := range activated {
+		switch name {
+		case NameHTTP:
+			ls.HTTP = l
+		case NameHTTPS:
+			ls.HTTPS = l
+		case NameUnix:
+			ls.Unix = l
+		default:
+			l.Close()
+			err = fmt.Errorf("unexpected socket %q from systemd, name it http, https or unix", name)
+		}
+	}
+	if err != nil {
+		ls.Close()
+		return nil, err
+	}
+
+	if ls.HTTP == nil && httpAddr != "" {
+		ls.HTTP, err = net.Listen("tcp", httpAddr)
+	}
+	if err == nil && ls.HTTPS == nil && httpsAddr != "" {
+		ls.HTTPS, err = net.Listen("tcp", httpsAddr)
+	}
+	if err == nil && ls.Unix == nil && unixPath != "" {
+		ls.Unix, err = Unix(unixPath)
+	}
+	if err != nil {
+		ls.Close()
+		return nil, fmt.Errorf("cannot listen: %w", err)
+	}
+	return ls, nil
+}
+
+// Close closes all listeners.
+func (ls *Listeners) Close() {
+	for _, l := range []net.Listener{ls.HTTP, ls.HTTPS, ls.Unix} {
+		if l != nil {
+			l.Close()
+		}
+	}
+}
+
+// Unix listens on the unix socket at p. A socket left behind by a previous
+// run is replaced.
+func Unix(p string) (net.Listener, error) {
+	if fi, err := os.Stat(p); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", p)
+}
+
+// systemd returns the sockets passed to the process with pid by their
+// names, see sd_listen_fds(3). The first one is the file descriptor first.
+// It returns nil if the process wasn't socket activated.
+func systemd(getenv func(string) string, pid, first int) (map[string]net.Listener, error) {
+	if getenv("LISTEN_PID") == "" {
+		return nil, nil
+	}
+	// children mustn't take the sockets for theirs
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if p, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || p != pid {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+
+	ls := make(map[string]net.Listener)
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(first+i), name)
+		l, err := net.FileListener(f)
+		// FileListener uses a copy of the file descriptor
+		f.Close()
+		if err == nil {
+			if _, ok := ls[name]; ok {
+				l.Close()
+				err = errors.New("there's already a socket with that name")
+			}
+		}
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("cannot use socket %q from systemd: %w", name, err)
+		}
+		ls[name] = l
+	}
+	return ls, nil
+}
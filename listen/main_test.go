@@ -0,0 +1,114 @@
+package listen
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestSystemd(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	env := map[string]string{
+		"LISTEN_PID":     "42",
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "https",
+	}
+	getenv := func(k string) string { return env[k] }
+
+	// the sockets are meant for another process
+	ls, err := systemd(getenv, 41, int(f.Fd()))
+	if err != nil || ls != nil {
+		t.Fatalf("expected no sockets for another pid, got %v, %v", ls, err)
+	}
+
+	ls, err = systemd(getenv, 42, int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := ls[NameHTTPS]
+	if !ok || len(ls) != 1 {
+		t.Fatalf("expected the https socket, got %v", ls)
+	}
+	defer l.Close()
+	if l.Addr().String() != orig.Addr().String() {
+		t.Errorf("expected a listener on %s, got %s", orig.Addr(), l.Addr())
+	}
+
+	go func() {
+		if c, err := net.Dial("tcp", orig.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	env["LISTEN_FDS"] = "x"
+	if _, err := systemd(getenv, 42, int(f.Fd())); err == nil {
+		t.Errorf("expected an error for an invalid LISTEN_FDS")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "raspi-dash.sock")
+	ls, err := Open("", "127.0.0.1:0", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ls.HTTP != nil || ls.HTTPS == nil || ls.Unix == nil {
+		t.Fatalf("unexpected listeners %+v", ls)
+	}
+	addr := ls.HTTPS.Addr().String()
+	ls.Close()
+
+	// a busy address fails all of them
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if _, err := Open(addr, busy.Addr().String(), ""); err == nil {
+		t.Fatal("expected an error for an address in use")
+	}
+	if l, err := net.Listen("tcp", addr); err != nil {
+		t.Errorf("expected %s to be closed again: %s", addr, err)
+	} else {
+		l.Close()
+	}
+}
+
+func TestUnix(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "raspi-dash.sock")
+
+	// the socket of a crashed run is still there
+	stale, err := net.Listen("unix", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := Unix(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := net.Dial("unix", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
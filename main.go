@@ -1,76 +1,389 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/pbaettig/raspi-dash/config"
 	"github.com/pbaettig/raspi-dash/letsencrypt"
+	"github.com/pbaettig/raspi-dash/listen"
 	"github.com/pbaettig/raspi-dash/router"
+	"github.com/pbaettig/raspi-dash/stats"
 )
 
 var (
-	sigs        chan os.Signal = make(chan os.Signal, 1)
-	httpServer  *http.Server
-	httpsServer *http.Server
+	hups    chan os.Signal = make(chan os.Signal, 1)
+	servers []*http.Server
+
+	// background goroutines that are waited for on shutdown, e.g. a
+	// certificate renewal that is writing the new certificate
+	background sync.WaitGroup
 )
 
-func interuptSignalHandler() {
-	<-sigs
-	log.Println("signal received, shutting down.")
-	httpServer.Close()
-	httpsServer.Close()
+// shutdown lets the servers finish the requests in flight, e.g. running
+// document downloads, waits for the certificate renewal and for the
+// collectors before the persisted series are flushed. Whatever isn't done before ctx expires is cut off.
+func shutdown(ctx context.Context) {
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("cannot shut down %s gracefully: %s", s.Addr, err.Error())
+			s.Close()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("background tasks did not stop in time: %s", ctx.Err().Error())
+	}
+
+	if err := stats.Stop(ctx); err != nil {
+		log.Println(err.Error())
+	}
 }
 
-func main() {
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go interuptSignalHandler()
+// loadConfig reads the config file at p. Without an explicit path a
+// missing config file at the default location isn't an error, the
+// defaults are used instead.
+func loadConfig(p string, explicit bool) (*config.Config, error) {
+	if !explicit {
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s does not exist, using the default config", p)
+			p = ""
+		}
+	}
+	return config.Load(p)
+}
+
+// applyConfig reloads everything that can be changed while running.
+func applyConfig(c *config.Config, rt *router.Router) error {
+	if err := stats.Reload(c); err != nil {
+		return err
+	}
+	return rt.Reload(c)
+}
+
+// hangupSignalHandler reloads the config on every SIGHUP. An invalid config
+// is ignored, one that fails to apply is rolled back to current.
+func hangupSignalHandler(ctx context.Context, load func() (*config.Config, error), current *config.Config, rt *router.Router) {
+	for {
+		select {
+		case <-hups:
+		case <-ctx.Done():
+			return
+		}
+		log.Println("SIGHUP received, reloading config.")
+
+		c, err := load()
+		if err != nil {
+			log.Printf("keeping the current config: %s", err.Error())
+			continue
+		}
+
+		if err := applyConfig(c, rt); err != nil {
+			log.Printf("cannot apply the new config, rolling back: %s", err.Error())
+			if err := applyConfig(current, rt); err != nil {
+				log.Printf("cannot roll back the config: %s", err.Error())
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(c.Listen, current.Listen) || c.Domain != current.Domain || !reflect.DeepEqual(c.TLS, current.TLS) || !reflect.DeepEqual(c.LetsEncrypt, current.LetsEncrypt) {
+			log.Println("listen, domain, tls and letsencrypt are only applied after a restart")
+		}
+		current = c
+		log.Println("config reloaded")
+	}
+}
 
+// newCerts describes the certificate requested for c.Domain.
+func newCerts(c *config.Config) (letsencrypt.Certs, error) {
+	le := c.LetsEncrypt
 	cs := letsencrypt.Certs{
-		Domains:        []string{config.ExternalDomainName},
-		CertFilePath:   config.TLSCertificateFilePath,
-		PrivateKeyPath: config.TLSPrivateKeyFilePath,
-		// CADirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
-		CADirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
-	}
-	if err := cs.RequestCerts(config.LetsEncryptUserName); err != nil {
-		if err != letsencrypt.ErrCertFilesAlreadyExist {
-			log.Fatalln(err.Error())
+		Domains:         append([]string{c.Domain}, le.Domains...),
+		CertFilePath:    le.CertFile,
+		PrivateKeyPath:  le.KeyFile,
+		CADirectoryURL:  le.DirectoryURL(),
+		AccountFilePath: le.AccountFile,
+		Challenge:       le.Challenge,
+		DNSResolvers:    le.DNS.Resolvers,
+	}
+	if le.Challenge != config.ChallengeDNS {
+		return cs, nil
+	}
+
+	var err error
+	switch le.DNS.Provider {
+	case "rfc2136":
+		cs.DNSProvider, err = letsencrypt.NewRFC2136Provider(le.DNS.RFC2136.Nameserver, le.DNS.RFC2136.TSIGKey, le.DNS.RFC2136.TSIGSecret, le.DNS.RFC2136.TSIGAlgorithm)
+	case "exec":
+		cs.DNSProvider, err = letsencrypt.NewExecProvider(le.DNS.Exec)
+	}
+	if err != nil {
+		return cs, fmt.Errorf("cannot set up the %s DNS provider: %w", le.DNS.Provider, err)
+	}
+	return cs, nil
+}
+
+// newCertManager returns what provides the certificate of the HTTPS
+// server according to c.TLS.Mode, and the local CA if one is used.
+func newCertManager(c *config.Config) (*letsencrypt.Manager, *letsencrypt.LocalCA, error) {
+	if c.TLS.Mode != config.TLSModeLocal {
+		cs, err := newCerts(c)
+		if err != nil {
+			return nil, nil, err
 		}
-		fmt.Println("cert files already exist")
+		m := letsencrypt.NewManager(cs, c.LetsEncrypt.Email)
+		m.RenewBefore = c.LetsEncrypt.RenewBefore
+		return m, nil, nil
+	}
+
+	lc := c.TLS.Local
+	ca, err := letsencrypt.LoadOrCreateLocalCA(lc.CACertFile, lc.CAKeyFile, "raspi-dash CA "+c.Domain)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	httpServer = &http.Server{
-		Addr:           ":8080",
-		Handler:        router.PermanentRedirectHandler,
+	cs := letsencrypt.Certs{
+		Domains:        []string{c.Domain},
+		CertFilePath:   lc.CertFile,
+		PrivateKeyPath: lc.KeyFile,
+	}
+	for _, h := range lc.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			cs.IPAddresses = append(cs.IPAddresses, ip)
+			continue
+		}
+		cs.Domains = append(cs.Domains, h)
+	}
+	return letsencrypt.NewLocalManager(cs, ca), ca, nil
+}
+
+// startCertManager returns the certificate manager for c, serving the
+// stored certificate or a self-signed one until it got a certificate.
+func startCertManager(c *config.Config) (*letsencrypt.Manager, *letsencrypt.LocalCA, error) {
+	certs, ca, err := newCertManager(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := certs.Load(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Println("no certificate yet, requesting one")
+		} else {
+			log.Printf("cannot load certificate, requesting a new one: %s", err.Error())
+		}
+		if err := certs.UseSelfSigned(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return certs, ca, nil
+}
+
+// newServer returns a server for h on l. There is no write timeout, it
+// would cut off document downloads, the router limits the other handlers.
+func newServer(l net.Listener, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           l.Addr().String(),
+		Handler:        h,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
+}
+
+// serve runs s on l in the background, with TLS if useTLS is set. Errors
+// other than being shut down are sent to failed.
+func serve(s *http.Server, l net.Listener, useTLS bool, failed chan<- error) {
+	servers = append(servers, s)
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalln(err.Error())
+		var err error
+		if useTLS {
+			err = s.ServeTLS(l, "", "")
+		} else {
+			err = s.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			failed <- fmt.Errorf("%s: %w", s.Addr, err)
 		}
 	}()
+}
 
-	httpsServer = &http.Server{
-		Addr:           ":8443",
-		Handler:        router.New(),
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+// disableHTTP2 makes s only offer HTTP/1.1.
+func disableHTTP2(s *http.Server) {
+	protos := make([]string, 0, len(s.TLSConfig.NextProtos))
+	for _, p := range s.TLSConfig.NextProtos {
+		if p != "h2" {
+			protos = append(protos, p)
+		}
+	}
+	s.TLSConfig.NextProtos = protos
+	// net/http doesn't add HTTP/2 on its own if TLSNextProto is set
+	s.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+}
+
+// accountAction runs one of the admin actions on the ACME account and
+// prints the account afterwards.
+func accountAction(cs letsencrypt.Certs, action string) error {
+	var err error
+	switch action {
+	case "show":
+	case "recover":
+		_, err = cs.RecoverAccount()
+	case "rollover":
+		err = cs.RollAccountKey()
+	default:
+		return fmt.Errorf("unknown account action %q, use show, recover or rollover", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	a, err := cs.Account()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("CA:    %s\nEmail: %s\n", cs.CADirectoryURL, a.Email)
+	if a.Registration != nil {
+		fmt.Printf("URI:   %s\nState: %s\n", a.Registration.URI, a.Registration.Body.Status)
+	}
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", config.DefaultPath, "path of the config file")
+	account := flag.String("account", "", "show, recover or rollover the ACME account and exit")
+	flag.Parse()
+
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+	})
+
+	cfg, err := loadConfig(*configPath, explicit)
+	if err != nil {
+		log.Fatalln(err.Error())
 	}
 
-	time.Sleep(5 * time.Second)
+	if *account != "" {
+		cs, err := newCerts(cfg)
+		if err == nil {
+			err = accountAction(cs, *account)
+		}
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		return
+	}
 
-	if err := httpsServer.ListenAndServeTLS(cs.CertFilePath, cs.PrivateKeyPath); err != nil && err != http.ErrServerClosed {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := stats.Start(ctx, cfg); err != nil {
 		log.Fatalln(err.Error())
 	}
 
-	os.Exit(0)
+	rt := router.New(cfg)
+	signal.Notify(hups, syscall.SIGHUP)
+	go hangupSignalHandler(ctx, func() (*config.Config, error) {
+		return loadConfig(*configPath, explicit)
+	}, cfg, rt)
+
+	trusted, err := router.ParseTrustedProxies(cfg.Listen.TrustedProxies)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	proxied := func(h http.Handler) http.Handler {
+		return router.ProxyHandler{Trusted: trusted, Next: h}
+	}
+
+	ls, err := listen.Open(cfg.Listen.HTTP, cfg.Listen.HTTPS, cfg.Listen.Unix)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	failed := make(chan error, 3)
+
+	if cfg.Listen.Mode == config.ListenModeHTTP {
+		if ls.HTTP == nil && ls.Unix == nil {
+			log.Fatalln("listen.http or listen.unix must be set in http mode")
+		}
+		if ls.HTTPS != nil {
+			log.Printf("not listening on %s in http mode", ls.HTTPS.Addr())
+			ls.HTTPS.Close()
+		}
+		if ls.HTTP != nil {
+			serve(newServer(ls.HTTP, proxied(rt)), ls.HTTP, false, failed)
+		}
+	} else {
+		if ls.HTTPS == nil {
+			log.Fatalln("listen.https must be set in https mode")
+		}
+
+		certs, ca, err := startCertManager(cfg)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		stats.Certs = certs
+
+		var redirect http.Handler = router.NewPermanentRedirectHandler(cfg.Domain)
+		if ca != nil {
+			// clients need the CA before they can trust the HTTPS server
+			rt.Handle("/ca.crt", ca)
+			mux := http.NewServeMux()
+			mux.Handle("/ca.crt", ca)
+			mux.Handle("/", redirect)
+			redirect = mux
+		}
+		if ls.HTTP != nil {
+			serve(newServer(ls.HTTP, proxied(certs.HTTPHandler(redirect))), ls.HTTP, false, failed)
+		}
+
+		s := newServer(ls.HTTPS, proxied(rt))
+		s.TLSConfig = certs.TLSConfig()
+		if !cfg.Listen.HTTP2 {
+			disableHTTP2(s)
+		}
+		serve(s, ls.HTTPS, true, failed)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			certs.Run(ctx)
+		}()
+	}
+
+	if ls.Unix != nil {
+		// only the reverse proxy can connect to the socket
+		serve(newServer(ls.Unix, router.ProxyHandler{TrustAll: true, Next: rt}), ls.Unix, false, failed)
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Println("signal received, shutting down.")
+	case err := <-failed:
+		log.Println(err.Error())
+		exitCode = 1
+	}
+	stop()
+
+	sctx, cancel := context.WithTimeout(context.Background(), cfg.Listen.ShutdownTimeout)
+	shutdown(sctx)
+	cancel()
+
+	os.Exit(exitCode)
 }
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+// written by Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+type Metric struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Family is a set of metrics sharing the same name, help text and type.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Metrics []Metric
+}
+
+func NewGauge(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: Gauge}
+}
+
+func NewCounter(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: Counter}
+}
+
+// Add adds a metric with value v to the family. labels are given as
+// name/value pairs.
+func (f *Family) Add(v float64, labels ...string) {
+	m := Metric{Value: v}
+	if len(labels) > 0 {
+		m.Labels = make(map[string]string)
+		for i := 0; i+1 < len(labels); i += 2 {
+			m.Labels[labels[i]] = labels[i+1]
+		}
+	}
+	f.Metrics = append(f.Metrics, m)
+}
+
+// Bool returns 1 for true and 0 for false.
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(labels[n]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Write writes fams to w in the Prometheus text exposition format. Families
+// without any metrics are left out.
+func Write(w io.Writer, fams []*Family) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range fams {
+		if len(f.Metrics) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, m := range f.Metrics {
+			fmt.Fprintf(bw, "%s%s %s\n", f.Name, formatLabels(m.Labels), formatValue(m.Value))
+		}
+	}
+
+	return bw.Flush()
+}
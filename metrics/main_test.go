@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	temp := NewGauge("raspi_cpu_temperature_celsius", "CPU temperature.")
+	temp.Add(46.2)
+
+	rx := NewCounter("raspi_network_receive_bytes_total", "Received bytes.\nCumulative.")
+	rx.Add(1024, "interface", "eth0")
+	rx.Add(math.Inf(1), "interface", `we"ird\`, "alias", "x")
+
+	empty := NewGauge("raspi_empty", "Never set.")
+
+	buf := new(bytes.Buffer)
+	if err := Write(buf, []*Family{temp, rx, empty}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP raspi_cpu_temperature_celsius CPU temperature.
+# TYPE raspi_cpu_temperature_celsius gauge
+raspi_cpu_temperature_celsius 46.2
+# HELP raspi_network_receive_bytes_total Received bytes.\nCumulative.
+# TYPE raspi_network_receive_bytes_total counter
+raspi_network_receive_bytes_total{interface="eth0"} 1024
+raspi_network_receive_bytes_total{alias="x",interface="we\"ird\\"} +Inf
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pbaettig/raspi-dash/alerts"
+)
+
+var (
+	defaultClient = &http.Client{Timeout: attemptTimeout}
+)
+
+// post sends body to url and fails for any status other than 2xx. Client
+// errors other than 429 are not retried.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("%s returned %s", url, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return backoff.Permanent(err)
+	}
+	return err
+}
+
+// Webhook posts every notification as JSON to URL.
+type Webhook struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Expr      string    `json:"expr"`
+	Series    string    `json:"series"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+}
+
+func (w Webhook) Name() string {
+	return "webhook"
+}
+
+func (w Webhook) Notify(ctx context.Context, a alerts.Alert) error {
+	body, err := json.Marshal(webhookPayload{
+		Status:    string(a.State),
+		Rule:      a.Rule.Name,
+		Expr:      a.Rule.Expr,
+		Series:    a.Rule.Series,
+		Value:     a.Value,
+		Threshold: a.Rule.Threshold,
+		Since:     a.Since,
+		Title:     Title(a),
+		Message:   Message(a),
+	})
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	h := http.Header{}
+	for k, vs := range w.Header {
+		h[k] = vs
+	}
+	h.Set("Content-Type", "application/json")
+
+	return post(ctx, w.Client, w.URL, h, body)
+}
+
+// Push posts the message as plain text to URL with the title, priority and
+// tags in headers, the way ntfy and similar push services expect it.
+type Push struct {
+	URL string
+	// Token is sent as bearer token if set
+	Token  string
+	Client *http.Client
+}
+
+func (p Push) Name() string {
+	return "push"
+}
+
+func (p Push) Notify(ctx context.Context, a alerts.Alert) error {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Title", Title(a))
+	if a.State == alerts.StateResolved {
+		h.Set("Priority", "default")
+		h.Set("Tags", "white_check_mark")
+	} else {
+		h.Set("Priority", "high")
+		h.Set("Tags", "warning")
+	}
+	if p.Token != "" {
+		h.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	return post(ctx, p.Client, p.URL, h, []byte(Message(a)))
+}
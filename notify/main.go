@@ -0,0 +1,293 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pbaettig/raspi-dash/alerts"
+)
+
+const (
+	queueSize = 64
+	// attemptTimeout bounds a single delivery attempt, so a hung sink
+	// doesn't hold up the notifications
+	attemptTimeout = 30 * time.Second
+)
+
+// Notifier delivers a single alert notification.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, a alerts.Alert) error
+}
+
+// Title is the one line summary of a notification for a.
+func Title(a alerts.Alert) string {
+	if a.State == alerts.StateResolved {
+		return fmt.Sprintf("[resolved] %s", a.Rule.Name)
+	}
+	return fmt.Sprintf("[%s] %s", a.State, a.Rule.Name)
+}
+
+// Message is the text of a notification for a.
+func Message(a alerts.Alert) string {
+	if a.State == alerts.StateResolved {
+		return fmt.Sprintf("%s is %.2f, resolved at %s (rule: %s)",
+			a.Rule.Series, a.Value, a.ResolvedAt.Format(time.RFC3339), a.Rule.Expr)
+	}
+	return fmt.Sprintf("%s is %.2f, firing since %s (rule: %s)",
+		a.Rule.Series, a.Value, a.FiredAt.Format(time.RFC3339), a.Rule.Expr)
+}
+
+// Dispatcher sends firing and resolved alerts to every notifier. Each alert
+// state is only sent once per notifier and a resolved alert is only sent
+// if its firing was, even if that delivery failed. Failed deliveries are
+// retried with an exponential backoff, if they still fail they are kept
+// and sent again with the next alerts, see Pending. Every notifier is
+// delivered to independently, one that is down doesn't delay the others.
+type Dispatcher struct {
+	Notifiers []Notifier
+	// NewBackOff returns the backoff policy for a single delivery
+	NewBackOff func() backoff.BackOff
+	// AttemptTimeout bounds every attempt of a delivery
+	AttemptTimeout time.Duration
+
+	mu     sync.Mutex
+	sent   map[string]alerts.State
+	failed map[string]alerts.Alert
+	queue  chan []alerts.Alert
+}
+
+func NewDispatcher(ns ...Notifier) *Dispatcher {
+	return &Dispatcher{
+		Notifiers: ns,
+		NewBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = 5 * time.Minute
+			return b
+		},
+		AttemptTimeout: attemptTimeout,
+		sent:           make(map[string]alerts.State),
+		failed:         make(map[string]alerts.Alert),
+		queue:          make(chan []alerts.Alert, queueSize),
+	}
+}
+
+func key(n Notifier, a alerts.Alert) string {
+	return n.Name() + "/" + a.Rule.Name
+}
+
+// due reports whether a still needs to be sent to n, if so it's marked as
+// sent.
+func (d *Dispatcher) due(n Notifier, a alerts.Alert) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	k := key(n, a)
+	last := d.sent[k]
+	due := false
+	switch a.State {
+	case alerts.StateFiring:
+		due = last != alerts.StateFiring
+	case alerts.StateResolved:
+		due = last == alerts.StateFiring
+	}
+	if due {
+		d.sent[k] = a.State
+	}
+	return due
+}
+
+// fail keeps a to be sent to n again with the next alerts.
+func (d *Dispatcher) fail(n Notifier, a alerts.Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failed[key(n, a)] = a
+}
+
+// retries removes and returns the failed alerts of n. Those whose rule has
+// a different state in as are dropped, the new state supersedes them.
+func (d *Dispatcher) retries(n Notifier, as []alerts.Alert) []alerts.Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	states := make(map[string]alerts.State)
+	for _, a := range as {
+		states[a.Rule.Name] = a.State
+	}
+
+	rs := make([]alerts.Alert, 0)
+	for k, a := range d.failed {
+		if !strings.HasPrefix(k, n.Name()+"/") {
+			continue
+		}
+		delete(d.failed, k)
+		if s, ok := states[a.Rule.Name]; !ok || s == a.State {
+			rs = append(rs, a)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Rule.Name < rs[j].Rule.Name })
+	return rs
+}
+
+// Pending reports whether there are failed deliveries that are sent again
+// with the next alerts, which may be none.
+func (d *Dispatcher) Pending() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.failed) > 0
+}
+
+// SetNotifiers replaces the notifiers alerts are sent to. Failed deliveries
+// to notifiers that are gone are dropped.
+func (d *Dispatcher) SetNotifiers(ns ...Notifier) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Notifiers = ns
+	for k, a := range d.failed {
+		kept := false
+		for _, n := range ns {
+			kept = kept || k == key(n, a)
+		}
+		if !kept {
+			delete(d.failed, k)
+		}
+	}
+}
+
+func (d *Dispatcher) notifiers() []Notifier {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Notifier{}, d.Notifiers...)
+}
+
+// Send delivers every alert in as that is due to all notifiers. It returns
+// once all of them succeeded or gave up.
+func (d *Dispatcher) Send(ctx context.Context, as []alerts.Alert) error {
+	ns := d.notifiers()
+	errs := make([]error, len(ns))
+
+	var wg sync.WaitGroup
+	for i, n := range ns {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			errs[i] = d.deliver(ctx, n, as)
+		}(i, n)
+	}
+	wg.Wait()
+
+	msgs := make([]string, 0)
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("notifications failed: %s", strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+// deliver retries the failed deliveries to n and sends every alert in as
+// that is due.
+func (d *Dispatcher) deliver(ctx context.Context, n Notifier, as []alerts.Alert) error {
+	errs := make([]string, 0)
+	retries := d.retries(n, as)
+	for i, a := range append(retries, as...) {
+		if i >= len(retries) && !d.due(n, a) {
+			continue
+		}
+
+		err := backoff.RetryNotify(func() error {
+			actx, cancel := context.WithTimeout(ctx, d.AttemptTimeout)
+			defer cancel()
+			return n.Notify(actx, a)
+		}, backoff.WithContext(d.NewBackOff(), ctx), func(err error, next time.Duration) {
+			log.Printf("%s: cannot notify about %s, retrying in %s: %s", n.Name(), a.Rule.Name, next, err.Error())
+		})
+		if err != nil {
+			d.fail(n, a)
+			errs = append(errs, fmt.Sprintf("%s: %s", n.Name(), err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Enqueue hands as to Run without blocking, alerts are dropped if the
+// queue is full.
+func (d *Dispatcher) Enqueue(as []alerts.Alert) {
+	select {
+	case d.queue <- as:
+	default:
+		log.Printf("notification queue is full, dropping %d alerts", len(as))
+	}
+}
+
+// delivery is a batch of alerts for a notifier.
+type delivery struct {
+	n  Notifier
+	as []alerts.Alert
+}
+
+// Run sends the enqueued alerts until ctx is done. Every notifier has a
+// queue of its own, which drops alerts once it's full.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	queues := make(map[string]chan delivery)
+	for {
+		select {
+		case as := <-d.queue:
+			for _, n := range d.notifiers() {
+				q, ok := queues[n.Name()]
+				if !ok {
+					q = make(chan delivery, queueSize)
+					queues[n.Name()] = q
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						d.work(ctx, q)
+					}()
+				}
+
+				select {
+				case q <- delivery{n, as}:
+				default:
+					log.Printf("%s: notification queue is full, dropping %d alerts", n.Name(), len(as))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// work delivers what's sent to q until ctx is done.
+func (d *Dispatcher) work(ctx context.Context, q <-chan delivery) {
+	for {
+		select {
+		case dl := <-q:
+			if err := d.deliver(ctx, dl.n, dl.as); err != nil {
+				log.Printf("notification failed: %s", err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pbaettig/raspi-dash/alerts"
+)
+
+type fakeNotifier struct {
+	mu       sync.Mutex
+	failures int
+	received []alerts.Alert
+}
+
+func (f *fakeNotifier) Name() string {
+	return "fake"
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, a alerts.Alert) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("unavailable")
+	}
+	f.received = append(f.received, a)
+	return nil
+}
+
+func testAlert(t *testing.T, state alerts.State) alerts.Alert {
+	r, err := alerts.ParseRule("hot", "cpuTemp > 80 for 5m clear 75")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alerts.Alert{Rule: r, State: state, Value: 85, Since: time.Now(), FiredAt: time.Now()}
+}
+
+func testDispatcher(ns ...Notifier) *Dispatcher {
+	d := NewDispatcher(ns...)
+	d.NewBackOff = func() backoff.BackOff {
+		return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+	}
+	return d
+}
+
+func TestDispatcher_Dedup(t *testing.T) {
+	f := new(fakeNotifier)
+	d := testDispatcher(f)
+	ctx := context.Background()
+
+	sends := [][]alerts.Alert{
+		// resolved without firing first is not sent
+		{testAlert(t, alerts.StateResolved)},
+		{testAlert(t, alerts.StatePending)},
+		{testAlert(t, alerts.StateFiring)},
+		{testAlert(t, alerts.StateFiring)},
+		{testAlert(t, alerts.StateResolved)},
+		{testAlert(t, alerts.StateResolved)},
+		{testAlert(t, alerts.StateFiring)},
+	}
+	for _, as := range sends {
+		if err := d.Send(ctx, as); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []alerts.State{alerts.StateFiring, alerts.StateResolved, alerts.StateFiring}
+	if len(f.received) != len(expected) {
+		t.Fatalf("received %d notifications but should be %d", len(f.received), len(expected))
+	}
+	for i, s := range expected {
+		if f.received[i].State != s {
+			t.Errorf("notification %d is %s but should be %s", i, f.received[i].State, s)
+		}
+	}
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	f := &fakeNotifier{failures: 2}
+	d := testDispatcher(f)
+
+	if err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateFiring)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.received) != 1 {
+		t.Fatalf("notification should be delivered after retrying")
+	}
+
+	// gives up after 3 retries and tries again with the next send
+	f.failures = 10
+	f.received = nil
+	if err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateResolved)}); err == nil {
+		t.Fatalf("send should fail")
+	}
+	f.failures = 0
+	if err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateResolved)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.received) != 1 {
+		t.Fatalf("failed notification should be sent again")
+	}
+}
+
+func TestDispatcher_FailedFiring(t *testing.T) {
+	f := &fakeNotifier{failures: 10}
+	d := testDispatcher(f)
+	ctx := context.Background()
+
+	if err := d.Send(ctx, []alerts.Alert{testAlert(t, alerts.StateFiring)}); err == nil {
+		t.Fatal("send should fail")
+	}
+	if !d.Pending() {
+		t.Fatal("failed firing should be pending")
+	}
+
+	// the next evaluation has no changes, the firing is sent anyway
+	f.failures = 0
+	if err := d.Send(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.received) != 1 || f.received[0].State != alerts.StateFiring || d.Pending() {
+		t.Fatalf("failed firing should be sent again, got %v", f.received)
+	}
+
+	// the rule resolves before the firing got through
+	f.received = nil
+	f.failures = 10
+	d.Send(ctx, []alerts.Alert{testAlert(t, alerts.StateFiring)})
+	d.Send(ctx, []alerts.Alert{testAlert(t, alerts.StateFiring)})
+	f.failures = 0
+	if err := d.Send(ctx, []alerts.Alert{testAlert(t, alerts.StateResolved)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.received) != 1 || f.received[0].State != alerts.StateResolved {
+		t.Fatalf("only the resolved notification should be sent, got %v", f.received)
+	}
+	if d.Pending() {
+		t.Errorf("resolved notification should supersede the failed firing")
+	}
+}
+
+func TestDispatcher_SetNotifiersDropsFailed(t *testing.T) {
+	d := testDispatcher(&fakeNotifier{failures: 10})
+	d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateFiring)})
+
+	d.SetNotifiers()
+	if d.Pending() {
+		t.Errorf("failed deliveries to removed notifiers should be dropped")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		calls   int
+		payload webhookPayload
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type is %s", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	d := testDispatcher(Webhook{URL: srv.URL})
+	if err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateFiring)}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("webhook was called %d times but should be 2", calls)
+	}
+	if payload.Status != "firing" || payload.Rule != "hot" || payload.Value != 85 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestWebhook_ClientError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	d := testDispatcher(Webhook{URL: srv.URL})
+	if err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateFiring)}); err == nil {
+		t.Fatal("send should fail")
+	}
+	if calls != 1 {
+		t.Errorf("client errors should not be retried, got %d calls", calls)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var (
+		title, priority, auth string
+		body                  []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title = r.Header.Get("Title")
+		priority = r.Header.Get("Priority")
+		auth = r.Header.Get("Authorization")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	p := Push{URL: srv.URL + "/alerts", Token: "secret"}
+	if err := p.Notify(context.Background(), testAlert(t, alerts.StateFiring)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "[firing] hot" || priority != "high" || auth != "Bearer secret" {
+		t.Errorf("unexpected headers: title %q, priority %q, auth %q", title, priority, auth)
+	}
+	if !strings.Contains(string(body), "cpuTemp is 85.00") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if err := p.Notify(context.Background(), testAlert(t, alerts.StateResolved)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "[resolved] hot" || priority != "default" {
+		t.Errorf("unexpected headers: title %q, priority %q", title, priority)
+	}
+}
+
+// hungNotifier never answers, like a sink that accepts the connection but
+// doesn't respond.
+type hungNotifier struct{}
+
+func (hungNotifier) Name() string {
+	return "hung"
+}
+
+func (hungNotifier) Notify(ctx context.Context, a alerts.Alert) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDispatcher_AttemptTimeout(t *testing.T) {
+	d := testDispatcher(hungNotifier{})
+	d.AttemptTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	err := d.Send(context.Background(), []alerts.Alert{testAlert(t, alerts.StateFiring)})
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected the attempts to time out, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("send took %s", d)
+	}
+}
+
+func TestDispatcher_RunIndependent(t *testing.T) {
+	f := new(fakeNotifier)
+	d := testDispatcher(hungNotifier{}, f)
+	d.AttemptTimeout = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	// the hung notifier doesn't hold up the other one
+	d.Enqueue([]alerts.Alert{testAlert(t, alerts.StateFiring)})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		n := len(f.received)
+		f.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notification wasn't delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/alerts"
+)
+
+// SMTP sends every notification as email. STARTTLS is used whenever the
+// server supports it, credentials are only sent if Username is set.
+type SMTP struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (s SMTP) Name() string {
+	return "smtp"
+}
+
+func (s SMTP) message(a alerts.Alert, now time.Time) []byte {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "From: %s\r\n", s.From)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(b, "Subject: %s\r\n", Title(a))
+	fmt.Fprintf(b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(b, "\r\n%s\r\n", Message(a))
+	return []byte(b.String())
+}
+
+func (s SMTP) Notify(ctx context.Context, a alerts.Alert) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{Timeout: attemptTimeout}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dl, ok := ctx.Deadline()
+	if !ok {
+		dl = time.Now().Add(attemptTimeout)
+	}
+	conn.SetDeadline(dl)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(a, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
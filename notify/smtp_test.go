@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/alerts"
+)
+
+// fakeSMTP accepts a single session and records the envelope and data.
+type fakeSMTP struct {
+	ln   net.Listener
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeSMTP{ln: ln, done: make(chan struct{})}
+	go f.serve()
+	return f
+}
+
+func (f *fakeSMTP) serve() {
+	defer close(f.done)
+
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			f.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			f.to = append(f.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			f.data = strings.Join(lines, "\n")
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	f := newFakeSMTP(t)
+	defer f.ln.Close()
+
+	s := SMTP{
+		Addr: f.ln.Addr().String(),
+		From: "dash@example.com",
+		To:   []string{"me@example.com", "you@example.com"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Notify(ctx, testAlert(t, alerts.StateFiring)); err != nil {
+		t.Fatal(err)
+	}
+	<-f.done
+
+	if f.from != "dash@example.com" {
+		t.Errorf("sender is %s", f.from)
+	}
+	if fmt.Sprint(f.to) != "[me@example.com you@example.com]" {
+		t.Errorf("recipients are %v", f.to)
+	}
+	if !strings.Contains(f.data, "Subject: [firing] hot") {
+		t.Errorf("mail has no subject:\n%s", f.data)
+	}
+}
+
+func TestSMTP_Unavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := SMTP{Addr: addr, From: "dash@example.com", To: []string{"me@example.com"}}
+	if err := s.Notify(context.Background(), testAlert(t, alerts.StateFiring)); err == nil {
+		t.Fatal("notify should fail without a server")
+	}
+}
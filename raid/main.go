@@ -0,0 +1,281 @@
+package raid
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+const (
+	maxHistory = 100
+
+	StateClean      = "clean"
+	StateDegraded   = "degraded"
+	StateResyncing  = "resyncing"
+	StateRecovering = "recovering"
+	StateChecking   = "checking"
+	StateInactive   = "inactive"
+
+	MemberActive     = "active"
+	MemberFaulty     = "faulty"
+	MemberSpare      = "spare"
+	MemberRebuilding = "rebuilding"
+)
+
+var (
+	memberRE = regexp.MustCompile(`^([^\[]+)\[(\d+)\]((?:\([A-Z]\))*)$`)
+)
+
+type Member struct {
+	Name  string `json:"name"`
+	Slot  int    `json:"slot"`
+	State string `json:"state"`
+}
+
+// Array is the state of a single md array.
+type Array struct {
+	Name          string   `json:"name"`
+	ActivityState string   `json:"activityState"`
+	State         string   `json:"state"`
+	Degraded      bool     `json:"degraded"`
+	DisksActive   int64    `json:"disksActive"`
+	DisksTotal    int64    `json:"disksTotal"`
+	DisksFailed   int64    `json:"disksFailed"`
+	DisksDown     int64    `json:"disksDown"`
+	DisksSpare    int64    `json:"disksSpare"`
+	Members       []Member `json:"members"`
+
+	// Only set while a resync, recovery or check is running
+	SyncAction      string        `json:"syncAction,omitempty"`
+	BlocksSynced    int64         `json:"blocksSynced"`
+	BlocksTotal     int64         `json:"blocksTotal"`
+	SyncProgressPct float64       `json:"syncProgressPercent"`
+	SyncETA         time.Duration `json:"syncETA"`
+	SyncSpeedKBs    float64       `json:"syncSpeedKBs"`
+}
+
+// Transition records a change of the state of an array or its members.
+type Transition struct {
+	Time  time.Time `json:"time"`
+	Array string    `json:"array"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+}
+
+// Monitor keeps track of the md arrays listed in <procRoot>/mdstat.
+type Monitor struct {
+	fs         procfs.FS
+	mdstatPath string
+
+	mu      sync.RWMutex
+	arrays  map[string]Array
+	history []Transition
+}
+
+func NewMonitor(procRoot string) (*Monitor, error) {
+	fs, err := procfs.NewFS(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Monitor{
+		fs:         fs,
+		mdstatPath: filepath.Join(procRoot, "mdstat"),
+		arrays:     make(map[string]Array),
+	}, nil
+}
+
+// parseMembers reads the flags of the component devices from the device
+// lines of mdstat, which procfs doesn't expose. The result is keyed by
+// array name.
+func parseMembers(mdstat string) map[string][]Member {
+	members := make(map[string][]Member)
+
+	for _, line := range strings.Split(mdstat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != ":" || !strings.HasPrefix(fields[0], "md") {
+			continue
+		}
+
+		ms := make([]Member, 0)
+		for _, f := range fields[3:] {
+			m := memberRE.FindStringSubmatch(f)
+			if m == nil {
+				continue
+			}
+			slot, _ := strconv.Atoi(m[2])
+
+			state := MemberActive
+			switch {
+			case strings.Contains(m[3], "(F)"):
+				state = MemberFaulty
+			case strings.Contains(m[3], "(S)"):
+				state = MemberSpare
+			}
+			ms = append(ms, Member{Name: m[1], Slot: slot, State: state})
+		}
+		sort.Slice(ms, func(i, j int) bool { return ms[i].Slot < ms[j].Slot })
+
+		members[fields[0]] = ms
+	}
+
+	return members
+}
+
+func newArray(md procfs.MDStat, members []Member) Array {
+	a := Array{
+		Name:          md.Name,
+		ActivityState: md.ActivityState,
+		DisksActive:   md.DisksActive,
+		DisksTotal:    md.DisksTotal,
+		DisksFailed:   md.DisksFailed,
+		DisksDown:     md.DisksDown,
+		DisksSpare:    md.DisksSpare,
+		Members:       members,
+		BlocksSynced:  md.BlocksSynced,
+		BlocksTotal:   md.BlocksTotal,
+	}
+	a.Degraded = md.DisksFailed > 0 || md.DisksDown > 0 || md.DisksActive < md.DisksTotal
+
+	switch md.ActivityState {
+	case StateResyncing, StateRecovering, StateChecking:
+		a.State = md.ActivityState
+		a.SyncAction = map[string]string{
+			StateResyncing:  "resync",
+			StateRecovering: "recovery",
+			StateChecking:   "check",
+		}[md.ActivityState]
+		a.SyncProgressPct = md.BlocksSyncedPct
+		a.SyncETA = time.Duration(md.BlocksSyncedFinishTime * float64(time.Minute))
+		a.SyncSpeedKBs = md.BlocksSyncedSpeed
+
+		// a device that was added to replace a failed one gets a number
+		// past the ones of the original disks
+		if md.ActivityState == StateRecovering {
+			for i := range a.Members {
+				if a.Members[i].State == MemberActive && int64(a.Members[i].Slot) >= md.DisksTotal {
+					a.Members[i].State = MemberRebuilding
+				}
+			}
+		}
+	case StateInactive:
+		a.State = StateInactive
+	default:
+		a.State = StateClean
+		if a.Degraded {
+			a.State = StateDegraded
+		}
+	}
+
+	return a
+}
+
+// summary describes the array and its members in a single line, any
+// change of it is recorded as a Transition.
+func (a Array) summary() string {
+	ms := make([]string, len(a.Members))
+	for i, m := range a.Members {
+		ms[i] = fmt.Sprintf("%s:%s", m.Name, m.State)
+	}
+	return fmt.Sprintf("%s [%s]", a.State, strings.Join(ms, " "))
+}
+
+// Update reads mdstat and records the state transitions since the last
+// update.
+func (m *Monitor) Update() error {
+	return m.update(time.Now())
+}
+
+func (m *Monitor) update(now time.Time) error {
+	mds, err := m.fs.MDStat()
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(m.mdstatPath)
+	if err != nil {
+		return err
+	}
+	members := parseMembers(string(raw))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	arrays := make(map[string]Array)
+	for _, md := range mds {
+		a := newArray(md, members[md.Name])
+		arrays[a.Name] = a
+
+		prev, ok := m.arrays[a.Name]
+		if !ok {
+			// the first time an array shows up is only interesting if
+			// we already knew other arrays before
+			if len(m.arrays) > 0 {
+				m.record(Transition{Time: now, Array: a.Name, From: "missing", To: a.summary()})
+			}
+			continue
+		}
+		if prev.summary() != a.summary() {
+			m.record(Transition{Time: now, Array: a.Name, From: prev.summary(), To: a.summary()})
+		}
+	}
+	for n, prev := range m.arrays {
+		if _, ok := arrays[n]; !ok {
+			m.record(Transition{Time: now, Array: n, From: prev.summary(), To: "missing"})
+		}
+	}
+	m.arrays = arrays
+
+	return nil
+}
+
+func (m *Monitor) record(t Transition) {
+	m.history = append(m.history, t)
+	if len(m.history) > maxHistory {
+		m.history = m.history[len(m.history)-maxHistory:]
+	}
+}
+
+// Arrays returns all arrays sorted by name.
+func (m *Monitor) Arrays() []Array {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	as := make([]Array, 0, len(m.arrays))
+	for _, a := range m.arrays {
+		as = append(as, a)
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Name < as[j].Name })
+
+	return as
+}
+
+// Degraded returns all arrays that are missing disks.
+func (m *Monitor) Degraded() []Array {
+	degraded := make([]Array, 0)
+	for _, a := range m.Arrays() {
+		if a.Degraded {
+			degraded = append(degraded, a)
+		}
+	}
+	return degraded
+}
+
+// History returns the recorded transitions, newest first.
+func (m *Monitor) History() []Transition {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h := make([]Transition, len(m.history))
+	for i, t := range m.history {
+		h[len(h)-1-i] = t
+	}
+	return h
+}
@@ -0,0 +1,122 @@
+package raid
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMonitor_Fixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		state    string
+		degraded bool
+		members  []Member
+	}{
+		{"clean", StateClean, false, []Member{
+			{"sda1", 0, MemberActive},
+			{"sdb1", 1, MemberActive},
+		}},
+		{"degraded", StateDegraded, true, []Member{
+			{"sda1", 0, MemberActive},
+			{"sdb1", 1, MemberFaulty},
+		}},
+		{"recovering", StateRecovering, true, []Member{
+			{"sda1", 0, MemberActive},
+			{"sdc1", 2, MemberRebuilding},
+			{"sdd1", 3, MemberSpare},
+		}},
+	}
+
+	for _, tt := range tests {
+		m, err := NewMonitor(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Update(); err != nil {
+			t.Fatalf("%s: %s", tt.fixture, err)
+		}
+
+		as := m.Arrays()
+		if len(as) != 1 {
+			t.Fatalf("%s: got %d arrays but should be 1", tt.fixture, len(as))
+		}
+		a := as[0]
+		if a.State != tt.state {
+			t.Errorf("%s: state is %s but should be %s", tt.fixture, a.State, tt.state)
+		}
+		if a.Degraded != tt.degraded {
+			t.Errorf("%s: degraded is %t but should be %t", tt.fixture, a.Degraded, tt.degraded)
+		}
+		if len(a.Members) != len(tt.members) {
+			t.Fatalf("%s: got members %v but should be %v", tt.fixture, a.Members, tt.members)
+		}
+		for i := range a.Members {
+			if a.Members[i] != tt.members[i] {
+				t.Errorf("%s: member [%d] is %v but should be %v", tt.fixture, i, a.Members[i], tt.members[i])
+			}
+		}
+	}
+}
+
+func TestMonitor_Recovery(t *testing.T) {
+	m, err := NewMonitor(filepath.Join("testdata", "recovering"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := m.Arrays()[0]
+	if a.SyncAction != "recovery" {
+		t.Errorf("sync action is %q but should be recovery", a.SyncAction)
+	}
+	if a.SyncProgressPct != 27.5 {
+		t.Errorf("progress is %f but should be 27.5", a.SyncProgressPct)
+	}
+	if want := time.Duration(123.4 * float64(time.Minute)); a.SyncETA != want {
+		t.Errorf("ETA is %s but should be %s", a.SyncETA, want)
+	}
+	if a.BlocksSynced != 537280000 {
+		t.Errorf("synced blocks are %d but should be 537280000", a.BlocksSynced)
+	}
+}
+
+func TestMonitor_History(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewMonitor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+	for i, fixture := range []string{"clean", "clean", "degraded", "recovering", "clean"} {
+		buf, err := os.ReadFile(filepath.Join("testdata", fixture, "mdstat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "mdstat"), buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.update(ts.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if fixture == "degraded" && len(m.Degraded()) != 1 {
+			t.Errorf("md0 should be reported as degraded")
+		}
+	}
+
+	h := m.History()
+	if len(h) != 3 {
+		t.Fatalf("got %d transitions but should be 3: %v", len(h), h)
+	}
+	if h[0].To != "clean [sda1:active sdb1:active]" {
+		t.Errorf("newest transition is to %q", h[0].To)
+	}
+	if h[2].From != "clean [sda1:active sdb1:active]" || h[2].To != "degraded [sda1:active sdb1:faulty]" {
+		t.Errorf("oldest transition is %q -> %q", h[2].From, h[2].To)
+	}
+}
@@ -0,0 +1,236 @@
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pbaettig/raspi-dash/raid"
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/pbaettig/raspi-dash/stats"
+	"gonum.org/v1/plot/plotter"
+)
+
+const (
+	defaultSeriesRange = time.Hour
+)
+
+type seriesQuery struct {
+	From, To  time.Time
+	Step      time.Duration
+	Aggregate series.Aggregate
+}
+
+type seriesPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type seriesResponse struct {
+	Name      string           `json:"name"`
+	From      int64            `json:"from"`
+	To        int64            `json:"to"`
+	Step      float64          `json:"step"`
+	Aggregate series.Aggregate `json:"aggregate"`
+	Points    []seriesPoint    `json:"points"`
+}
+
+// parseTime accepts unix timestamps in seconds as well as RFC3339.
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseStep accepts durations like 5m as well as plain seconds.
+func parseStep(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func parseSeriesQuery(r *http.Request) (seriesQuery, error) {
+	var err error
+	q := seriesQuery{
+		To: time.Now(),
+	}
+	q.From = q.To.Add(-defaultSeriesRange)
+
+	if v := r.FormValue("from"); v != "" {
+		if q.From, err = parseTime(v); err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := r.FormValue("to"); v != "" {
+		if q.To, err = parseTime(v); err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if q.To.Before(q.From) {
+		return q, fmt.Errorf("to is before from")
+	}
+	if v := r.FormValue("step"); v != "" {
+		if q.Step, err = parseStep(v); err != nil {
+			return q, fmt.Errorf("invalid step: %w", err)
+		}
+	}
+	if q.Aggregate, err = series.ParseAggregate(r.FormValue("agg")); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+func (q seriesQuery) run(s *series.Series) seriesResponse {
+	xys := series.Resample(s.Range(q.From, q.To, q.Aggregate), q.Step, q.Aggregate)
+
+	return seriesResponse{
+		Name:      s.Name,
+		From:      q.From.Unix(),
+		To:        q.To.Unix(),
+		Step:      q.Step.Seconds(),
+		Aggregate: q.Aggregate,
+		Points:    toSeriesPoints(xys),
+	}
+}
+
+func toSeriesPoints(xys plotter.XYs) []seriesPoint {
+	ps := make([]seriesPoint, len(xys))
+	for i, xy := range xys {
+		ps[i] = seriesPoint{Timestamp: int64(xy.X), Value: xy.Y}
+	}
+	return ps
+}
+
+func wantsCSV(r *http.Request) bool {
+	if f := r.FormValue("format"); f != "" {
+		return f == "csv"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache, must-revalidate")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeSeriesCSV(w http.ResponseWriter, rs []seriesResponse) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Add("Cache-Control", "no-cache, must-revalidate")
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"series", "timestamp", "value"})
+	for _, r := range rs {
+		for _, p := range r.Points {
+			cw.Write([]string{
+				r.Name,
+				strconv.FormatInt(p.Timestamp, 10),
+				strconv.FormatFloat(p.Value, 'f', -1, 64),
+			})
+		}
+	}
+	cw.Flush()
+}
+
+func seriesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := parseSeriesQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n := mux.Vars(r)["name"]
+	s, ok := stats.AllSeries()[n]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no series with name %s found", n), http.StatusNotFound)
+		return
+	}
+
+	res := q.run(s)
+	if wantsCSV(r) {
+		writeSeriesCSV(w, []seriesResponse{res})
+		return
+	}
+	writeJSON(w, res)
+}
+
+func allSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := parseSeriesQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	all := stats.AllSeries()
+	names := make([]string, 0, len(all))
+	for n := range all {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	res := make([]seriesResponse, 0, len(names))
+	for _, n := range names {
+		res = append(res, q.run(all[n]))
+	}
+
+	if wantsCSV(r) {
+		writeSeriesCSV(w, res)
+		return
+	}
+	writeJSON(w, res)
+}
+
+func collectorsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, stats.Collectors.Status())
+}
+
+func diskIOHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, stats.LatestDiskIO())
+}
+
+type raidResponse struct {
+	Arrays   []raid.Array      `json:"arrays"`
+	Degraded []raid.Array      `json:"degraded"`
+	History  []raid.Transition `json:"history"`
+}
+
+func raidHandler(w http.ResponseWriter, r *http.Request) {
+	if stats.RAID == nil {
+		http.Error(w, "RAID monitoring is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, raidResponse{
+		Arrays:   stats.RAID.Arrays(),
+		Degraded: stats.RAID.Degraded(),
+		History:  stats.RAID.History(),
+	})
+}
+
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if stats.Alerts == nil {
+		http.Error(w, "alerting is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, stats.Alerts.Alerts())
+}
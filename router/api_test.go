@@ -0,0 +1,178 @@
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/pbaettig/raspi-dash/stats"
+)
+
+func TestParseSeriesQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+		want  seriesQuery
+	}{
+		{query: "from=100&to=200", want: seriesQuery{From: time.Unix(100, 0), To: time.Unix(200, 0), Aggregate: series.Avg}},
+		{query: "from=1970-01-01T00:01:40Z&to=200&step=5m&agg=max", want: seriesQuery{From: time.Unix(100, 0), To: time.Unix(200, 0), Step: 5 * time.Minute, Aggregate: series.Max}},
+		{query: "from=100&to=100&step=60", want: seriesQuery{From: time.Unix(100, 0), To: time.Unix(100, 0), Step: time.Minute, Aggregate: series.Avg}},
+		{query: "from=yesterday", err: "invalid from"},
+		{query: "to=2021-13-01T00:00:00Z", err: "invalid to"},
+		{query: "from=200&to=100", err: "to is before from"},
+		{query: "from=100&to=200&step=often", err: "invalid step"},
+		{query: "from=100&to=200&agg=median", err: "unknown aggregate"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/series?"+tt.query, nil)
+		got, err := parseSeriesQuery(req)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tt.query, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.query, err)
+			continue
+		}
+		if !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) || got.Step != tt.want.Step || got.Aggregate != tt.want.Aggregate {
+			t.Errorf("%s: got %+v but should be %+v", tt.query, got, tt.want)
+		}
+	}
+
+	// the last hour by default
+	got, err := parseSeriesQuery(httptest.NewRequest(http.MethodGet, "/api/series", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := got.To.Sub(got.From); d != defaultSeriesRange || time.Since(got.To) > time.Minute {
+		t.Errorf("default range is %s until %s", d, got.To)
+	}
+}
+
+func TestSeriesHandlers(t *testing.T) {
+	c := config.Default()
+	c.Paths.Documents = t.TempDir()
+	rt := New(c)
+
+	s := series.NewSeries("api.test", 10)
+	stats.AllPlots["apiTest"] = stats.SingleValuePlot{Value: s}
+	t.Cleanup(func() { delete(stats.AllPlots, "apiTest") })
+	base := time.Now().Add(-30 * time.Minute).Truncate(time.Minute)
+	for i, v := range []float64{40, 41, 42} {
+		if err := s.Push(base.Add(time.Duration(i)*time.Second), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q := url.Values{
+		"from": {fmt.Sprint(base.Unix())},
+		"to":   {fmt.Sprint(base.Add(time.Minute).Unix())},
+	}
+
+	get := func(path string, q url.Values, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+q.Encode(), nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/series/api.test", q, "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var res seriesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := []seriesPoint{{base.Unix(), 40}, {base.Unix() + 1, 41}, {base.Unix() + 2, 42}}
+	if res.Name != "api.test" || res.From != base.Unix() || res.Aggregate != series.Avg || !reflect.DeepEqual(res.Points, want) {
+		t.Errorf("unexpected series %+v", res)
+	}
+
+	// resampled to a single bucket
+	q.Set("step", "1m")
+	q.Set("agg", "max")
+	rec = get("/api/series/api.test", q, "")
+	res = seriesResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want := []seriesPoint{{base.Unix(), 42}}; res.Step != 60 || !reflect.DeepEqual(res.Points, want) {
+		t.Errorf("unexpected resampled series %+v", res)
+	}
+
+	rec = get("/api/series/api.test", q, "text/csv")
+	if rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected CSV, got %s", rec.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"series", "timestamp", "value"}, {"api.test", fmt.Sprint(base.Unix()), "42"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("got CSV %v but should be %v", records, want)
+	}
+
+	// format takes precedence over the Accept header
+	q.Set("format", "json")
+	if rec = get("/api/series/api.test", q, "text/csv"); rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	q.Set("format", "csv")
+	rec = get("/api/series", q, "")
+	records, err = csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, r := range records[1:] {
+		found = found || reflect.DeepEqual(r, []string{"api.test", fmt.Sprint(base.Unix()), "42"})
+	}
+	if !found {
+		t.Errorf("api.test missing from all series %v", records)
+	}
+
+	q.Del("format")
+	rec = get("/api/series", q, "")
+	var all []seriesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(stats.AllSeries()) {
+		t.Errorf("expected %d series, got %d", len(stats.AllSeries()), len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Name >= all[i].Name {
+			t.Errorf("series should be sorted by name, got %s before %s", all[i-1].Name, all[i].Name)
+		}
+	}
+
+	for _, tt := range []struct {
+		path  string
+		query string
+		code  int
+	}{
+		{"/api/series/nonexistent", "", http.StatusNotFound},
+		{"/api/series/api.test", "from=200&to=100", http.StatusBadRequest},
+		{"/api/series", "from=yesterday", http.StatusBadRequest},
+	} {
+		q, _ := url.ParseQuery(tt.query)
+		if rec := get(tt.path, q, ""); rec.Code != tt.code {
+			t.Errorf("%s?%s: expected %d, got %d", tt.path, tt.query, tt.code, rec.Code)
+		}
+	}
+}
@@ -3,14 +3,16 @@ package router
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/pbaettig/raspi-dash/config"
 	"github.com/pbaettig/raspi-dash/docs"
+	"github.com/pbaettig/raspi-dash/metrics"
 	"github.com/pbaettig/raspi-dash/stats"
 	"github.com/pbaettig/raspi-dash/templates"
 )
@@ -52,37 +54,40 @@ func plotHandler(w http.ResponseWriter, r *http.Request) {
 	writePlot(p, rv, w)
 }
 
-func docByIdHandler(w http.ResponseWriter, r *http.Request) {
-	var (
-		err error
-		buf []byte
-	)
-
-	vars := mux.Vars(r)
-	id := vars["id"]
-	if m, _ := regexp.MatchString(`^\d{3}$`, id); !m {
-		http.Error(w, "id not recognized", http.StatusBadRequest)
-		return
-	}
-
-	found, err := docs.FS.FindById(id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("no document with id %s found", id), http.StatusNotFound)
-		return
-	}
-
-	if len(found) == 1 {
-		buf, err = docs.FS.ReadFile(found[0])
-	} else {
-		buf, err = docs.FS.ZipFiles(found)
+func docByIdHandler(documents func() docs.Documents) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			err error
+			buf []byte
+			d   = documents()
+		)
+
+		vars := mux.Vars(r)
+		id := vars["id"]
+		if m, _ := regexp.MatchString(`^\d{3}$`, id); !m {
+			http.Error(w, "id not recognized", http.StatusBadRequest)
+			return
+		}
+
+		found, err := d.FindById(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("no document with id %s found", id), http.StatusNotFound)
+			return
+		}
+
+		if len(found) == 1 {
+			buf, err = d.ReadFile(found[0])
+		} else {
+			buf, err = d.ZipFiles(found)
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(buf)
 	}
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(buf)
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,14 +97,39 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type RedirectHandler int
+// RedirectHandler redirects every request to the same host, path and query
+// with HTTPS. Requests without a host are redirected to Domain.
+type RedirectHandler struct {
+	Code   int
+	Domain string
+}
 
 func (h RedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	url := strings.TrimPrefix(r.URL.Path, "/")
-	w.Header().Set("Location", fmt.Sprintf("https://%s/%s", config.ExternalDomainName, url))
-	w.WriteHeader(int(h))
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+	if host == "" {
+		host = h.Domain
+	}
+
+	u := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(h.Code)
 }
 
-var (
-	PermanentRedirectHandler = RedirectHandler(http.StatusMovedPermanently)
-)
+// NewPermanentRedirectHandler redirects to domain with 301 Moved
+// Permanently.
+func NewPermanentRedirectHandler(domain string) RedirectHandler {
+	return RedirectHandler{Code: http.StatusMovedPermanently, Domain: domain}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Write(w, stats.Metrics(r.Context())); err != nil {
+		log.Printf("cannot write metrics: %s", err.Error())
+	}
+}
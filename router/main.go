@@ -1,36 +1,118 @@
 package router
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pbaettig/raspi-dash/assets"
 	"github.com/pbaettig/raspi-dash/auth"
 	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/docs"
 )
 
-var (
-	allowedUsers *auth.BasicAuthUsers = new(auth.BasicAuthUsers)
-)
+// handlerTimeout is how long a handler may take to respond. The documents
+// have no timeout, large downloads can take much longer.
+const handlerTimeout = 10 * time.Second
+
+// timeout responds with 503 Service Unavailable if h takes longer than
+// handlerTimeout.
+func timeout(h http.HandlerFunc) http.Handler {
+	return http.TimeoutHandler(h, handlerTimeout, "")
+}
 
-func New() *mux.Router {
-	allowedUsers.PopulateFromEnv()
+// Router serves the dashboard. The documents and the users allowed to
+// access them can be changed with Reload while it is serving.
+type Router struct {
+	*mux.Router
 
-	r := mux.NewRouter()
-	r.HandleFunc("/plot/{name}", plotHandler)
-	r.PathPrefix("/assets").Handler(http.StripPrefix("/assets", http.FileServer(http.FS(assets.FS))))
-	r.HandleFunc("/", indexHandler)
-	r.HandleFunc("/api/docs/{id}", docByIdHandler)
+	allowedUsers *auth.BasicAuthUsers
+
+	mu        sync.RWMutex
+	documents docs.Documents
+}
+
+func New(c *config.Config) *Router {
+	rt := &Router{
+		Router:       mux.NewRouter(),
+		allowedUsers: new(auth.BasicAuthUsers),
+	}
+	if err := rt.Reload(c); err != nil {
+		// documents that aren't there yet shouldn't keep the dashboard
+		// from starting
+		log.Println(err.Error())
+		rt.documents = docs.NewDocuments(c.Paths.Documents)
+		rt.allowedUsers.Set(users(c))
+	}
+
+	r := rt.Router
+	r.Handle("/plot/{name}", timeout(plotHandler))
+	r.Handle("/metrics", timeout(metricsHandler))
+	r.PathPrefix("/assets").Handler(timeout(http.StripPrefix("/assets", http.FileServer(http.FS(assets.FS))).ServeHTTP))
+	r.Handle("/", timeout(indexHandler))
+	r.HandleFunc("/api/docs/{id}", docByIdHandler(rt.Documents))
+	r.Handle("/api/series", timeout(allSeriesHandler))
+	r.Handle("/api/series/{name}", timeout(seriesHandler))
+	r.Handle("/api/collectors", timeout(collectorsHandler))
+	r.Handle("/api/diskio", timeout(diskIOHandler))
+	r.Handle("/api/raid", timeout(raidHandler))
+	r.Handle("/api/alerts", timeout(alertsHandler))
 
 	private := r.PathPrefix("/private").Subrouter()
-	private.Path("/docs/id/{id}").HandlerFunc(docByIdHandler)
-	private.Use(allowedUsers.Middleware)
+	private.Path("/docs/id/{id}").HandlerFunc(docByIdHandler(rt.Documents))
+	private.Use(rt.allowedUsers.Middleware)
 
 	docs := r.PathPrefix("/documents").Subrouter()
-	docs.NewRoute().Handler(http.StripPrefix("/documents", http.FileServer(
-		http.FS(os.DirFS(config.DocumentsPath)))))
-	docs.Use(allowedUsers.Middleware)
+	docs.NewRoute().Handler(http.StripPrefix("/documents", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.FileServer(http.FS(rt.Documents())).ServeHTTP(w, r)
+	})))
+	docs.Use(rt.allowedUsers.Middleware)
+
+	return rt
+}
+
+// users merges the users of the config with the ones from the environment,
+// which take precedence.
+func users(c *config.Config) map[string]string {
+	us := make(map[string]string)
+	for u, p := range c.Users {
+		us[strings.ToLower(u)] = p
+	}
+	for u, p := range auth.UsersFromEnv() {
+		us[u] = p
+	}
+	return us
+}
+
+// Documents returns the documents that are currently served.
+func (rt *Router) Documents() docs.Documents {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	return rt.documents
+}
+
+// Reload switches to the documents and users of c. Nothing is changed if
+// the documents path isn't a directory.
+func (rt *Router) Reload(c *config.Config) error {
+	fi, err := os.Stat(c.Paths.Documents)
+	if err != nil {
+		return fmt.Errorf("cannot serve documents: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("cannot serve documents: %s is not a directory", c.Paths.Documents)
+	}
+
+	rt.mu.Lock()
+	rt.documents = docs.NewDocuments(c.Paths.Documents)
+	rt.mu.Unlock()
+
+	rt.allowedUsers.Set(users(c))
 
-	return r
+	return nil
 }
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbaettig/raspi-dash/config"
+)
+
+func TestRouter_Reload(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "123_letter.pdf"), []byte("letter"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.Default()
+	c.Paths.Documents = dir
+	c.Users = map[string]string{"alice": "secret"}
+	rt := New(c)
+
+	get := func(user, password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/documents/123_letter.pdf", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("alice", "secret"); code != http.StatusOK {
+		t.Errorf("alice should have access, got %d", code)
+	}
+	if code := get("bob", "secret"); code != http.StatusForbidden {
+		t.Errorf("bob should not have access, got %d", code)
+	}
+
+	invalid := config.Default()
+	invalid.Paths.Documents = filepath.Join(dir, "missing")
+	invalid.Users = map[string]string{"bob": "secret"}
+	if err := rt.Reload(invalid); err == nil {
+		t.Fatal("reload with a missing documents path should fail")
+	}
+	if code := get("alice", "secret"); code != http.StatusOK {
+		t.Errorf("failed reload should keep the users, got %d", code)
+	}
+
+	other := t.TempDir()
+	c2 := config.Default()
+	c2.Paths.Documents = other
+	c2.Users = map[string]string{"bob": "secret"}
+	if err := rt.Reload(c2); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("alice", "secret"); code != http.StatusForbidden {
+		t.Errorf("alice should be removed, got %d", code)
+	}
+	if code := get("bob", "secret"); code != http.StatusNotFound {
+		t.Errorf("bob should see the new, empty documents directory, got %d", code)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	h := NewPermanentRedirectHandler("home.example.org")
+
+	tests := []struct {
+		host, target, want string
+	}{
+		{"raspi.lan:8080", "/plot/cpu?range=900", "https://raspi.lan/plot/cpu?range=900"},
+		{"home.example.org", "/", "https://home.example.org/"},
+		{"[fd00::10]:8080", "/api/series?name=a%20b", "https://[fd00::10]/api/series?name=a%20b"},
+		{"", "/documents/", "https://home.example.org/documents/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s: expected redirect to %s, got %d %s", tt.host, tt.target, tt.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
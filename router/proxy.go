@@ -0,0 +1,113 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyHandler takes the client address, host and scheme of requests from
+// trusted reverse proxies from their X-Forwarded-For, X-Forwarded-Host and
+// X-Forwarded-Proto headers before passing them on to Next. The headers of
+// every other request are removed, so they can't be spoofed.
+type ProxyHandler struct {
+	// Trusted are the networks of the proxies
+	Trusted []*net.IPNet
+	// TrustAll trusts every client, e.g. on a unix socket only the proxy
+	// can connect to. Only the last X-Forwarded-For entry is taken from
+	// them, unless it's in Trusted as well.
+	TrustAll bool
+	Next     http.Handler
+}
+
+// ParseTrustedProxies parses IP addresses and networks in CIDR notation.
+func ParseTrustedProxies(ss []string) ([]*net.IPNet, error) {
+	ns := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is neither an IP address nor a network", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ns = append(ns, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP address nor a network", s)
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+func (h ProxyHandler) trusted(addr string) bool {
+	return h.TrustAll || h.trustedProxy(addr)
+}
+
+// trustedProxy reports whether addr is in one of the Trusted networks.
+func (h ProxyHandler) trustedProxy(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	forwardedFor := r.Header.Values("X-Forwarded-For")
+	host := r.Header.Get("X-Forwarded-Host")
+	proto := r.Header.Get("X-Forwarded-Proto")
+	for _, k := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+		r.Header.Del(k)
+	}
+
+	if !h.trusted(r.RemoteAddr) {
+		h.Next.ServeHTTP(w, r)
+		return
+	}
+
+	// every proxy appends the address it got the request from, the client
+	// is the last one that isn't a trusted proxy itself. Anything before it
+	// was sent by the client and can't be trusted.
+	var addrs []string
+	for _, v := range forwardedFor {
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if net.ParseIP(addrs[i]) == nil {
+			break
+		}
+		r.RemoteAddr = net.JoinHostPort(addrs[i], "0")
+		if !h.trustedProxy(addrs[i]) {
+			break
+		}
+	}
+	if host != "" {
+		r.Host = host
+	}
+	if proto == "http" || proto == "https" {
+		r.URL.Scheme = proto
+	}
+
+	h.Next.ServeHTTP(w, r)
+}
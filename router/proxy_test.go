@@ -0,0 +1,72 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHandler(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.1", "fd00::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	h := ProxyHandler{Trusted: trusted, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	})}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		wantAddr   string
+		wantHost   string
+		wantScheme string
+	}{
+		{"trusted proxy", "10.0.0.1:4711", "192.0.2.7", "192.0.2.7:0", "dash.example.org", "https"},
+		{"chain of trusted proxies", "10.0.0.1:4711", "192.0.2.7, 203.0.113.9, fd00::2", "203.0.113.9:0", "dash.example.org", "https"},
+		{"untrusted client", "192.0.2.8:4711", "10.0.0.1", "192.0.2.8:4711", "example.com", "http"},
+		{"IPv6 proxy", "[fd00::1]:4711", "2001:db8::7", "[2001:db8::7]:0", "dash.example.org", "https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+			req.Header.Set("X-Forwarded-Host", "dash.example.org")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got.RemoteAddr != tt.wantAddr || got.Host != tt.wantHost || got.URL.Scheme != tt.wantScheme {
+				t.Errorf("expected %s %s %s, got %s %s %s", tt.wantAddr, tt.wantHost, tt.wantScheme, got.RemoteAddr, got.Host, got.URL.Scheme)
+			}
+			for _, k := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+				if got.Header.Get(k) != "" {
+					t.Errorf("%s should be removed", k)
+				}
+			}
+		})
+	}
+
+	// everyone is trusted on a unix socket, but only for the last hop
+	h = ProxyHandler{TrustAll: true, Next: h.Next}
+	for _, forwarded := range []string{"192.0.2.7", "10.0.0.1, 192.0.2.7", "198.51.100.1, 192.0.2.7"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "@"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got.RemoteAddr != "192.0.2.7:0" {
+			t.Errorf("expected the last hop of %q on a unix socket, got %s", forwarded, got.RemoteAddr)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, s := range []string{"10.0.0.300", "proxy.lan", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies([]string{s}); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
@@ -3,6 +3,14 @@ set -e
 
 executable_name='raspi-dash'
 raspi_executable_path="/usr/local/bin/$executable_name"
+config_path="/etc/$executable_name/config.yaml"
+
+# the domain and letsencrypt email have no defaults, so the config has to be
+# written by hand before the first deploy
+if ! ssh raspi "sudo test -e $config_path"; then
+    echo "$config_path is missing on raspi, create it from config.example.yaml with the real domain and letsencrypt.email" >&2
+    exit 1
+fi
 
 GOOS=linux GOARCH=arm GOARM=5 go build -o raspi-dash main.go
 scp ./raspi-dash raspi:/tmp/
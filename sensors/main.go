@@ -0,0 +1,267 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoReadings = errors.New("no temperature readings available")
+
+	// names of thermal zones and hwmon chips that measure the CPU
+	cpuSensorNames = []string{"cpu-thermal", "cpu_thermal", "soc_thermal", "soc-thermal", "x86_pkg_temp", "coretemp", "k10temp"}
+)
+
+// Reading is a temperature reported by a sensor.
+type Reading struct {
+	Sensor  string  `json:"sensor"`
+	Celsius float64 `json:"celsius"`
+}
+
+// Backend is a source of temperature readings.
+type Backend interface {
+	Name() string
+	Temperatures(ctx context.Context) ([]Reading, error)
+}
+
+func readTrimmed(p string) (string, error) {
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// readMilliCelsius reads a sysfs temperature file, which holds the
+// temperature in millidegree Celsius.
+func readMilliCelsius(p string) (float64, error) {
+	s, err := readTrimmed(p)
+	if err != nil {
+		return 0, err
+	}
+	mc, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse temperature in %s: %w", p, err)
+	}
+	return float64(mc) / 1000, nil
+}
+
+// ThermalZones reads <Root>/class/thermal/thermal_zone*.
+type ThermalZones struct {
+	Root string
+}
+
+func (tz ThermalZones) Name() string {
+	return "thermal"
+}
+
+func (tz ThermalZones) Temperatures(ctx context.Context) ([]Reading, error) {
+	zones, err := filepath.Glob(filepath.Join(tz.Root, "class", "thermal", "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]Reading, 0, len(zones))
+	for _, z := range zones {
+		t, err := readMilliCelsius(filepath.Join(z, "temp"))
+		if err != nil {
+			continue
+		}
+		name, err := readTrimmed(filepath.Join(z, "type"))
+		if err != nil || name == "" {
+			name = filepath.Base(z)
+		}
+		rs = append(rs, Reading{Sensor: name, Celsius: t})
+	}
+
+	return rs, nil
+}
+
+var (
+	hwmonInputRE = regexp.MustCompile(`^temp(\d+)_input$`)
+)
+
+// Hwmon reads <Root>/class/hwmon/hwmon*/temp*_input.
+type Hwmon struct {
+	Root string
+}
+
+func (h Hwmon) Name() string {
+	return "hwmon"
+}
+
+func (h Hwmon) Temperatures(ctx context.Context) ([]Reading, error) {
+	chips, err := filepath.Glob(filepath.Join(h.Root, "class", "hwmon", "hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]Reading, 0)
+	for _, c := range chips {
+		chip, err := readTrimmed(filepath.Join(c, "name"))
+		if err != nil || chip == "" {
+			chip = filepath.Base(c)
+		}
+
+		inputs, err := filepath.Glob(filepath.Join(c, "temp*_input"))
+		if err != nil {
+			continue
+		}
+		sort.Strings(inputs)
+
+		for _, in := range inputs {
+			m := hwmonInputRE.FindStringSubmatch(filepath.Base(in))
+			if m == nil {
+				continue
+			}
+			t, err := readMilliCelsius(in)
+			if err != nil {
+				continue
+			}
+
+			name := chip
+			if label, err := readTrimmed(filepath.Join(c, "temp"+m[1]+"_label")); err == nil && label != "" {
+				name = chip + "/" + label
+			} else if len(inputs) > 1 {
+				name = chip + "/temp" + m[1]
+			}
+			rs = append(rs, Reading{Sensor: name, Celsius: t})
+		}
+	}
+
+	return rs, nil
+}
+
+// RunVcgencmd runs vcgencmd with cmd and splits its key=value output.
+func RunVcgencmd(ctx context.Context, cmd ...string) (string, string, error) {
+	out, err := exec.CommandContext(ctx, "vcgencmd", cmd...).Output()
+	if err != nil {
+		return "", "", err
+	}
+
+	split := strings.SplitN(strings.TrimSpace(string(out)), "=", 2)
+	if len(split) != 2 {
+		return "", "", fmt.Errorf("cannot parse vcgencmd output: %s", string(out))
+	}
+	return split[0], split[1], nil
+}
+
+var (
+	vcgencmdTempRE = regexp.MustCompile(`^([\d\.]+)`)
+)
+
+// Vcgencmd reads the SoC temperature with `vcgencmd measure_temp`, only
+// available on Raspberry Pi OS.
+type Vcgencmd struct{}
+
+func (v Vcgencmd) Name() string {
+	return "vcgencmd"
+}
+
+func (v Vcgencmd) Temperatures(ctx context.Context) ([]Reading, error) {
+	_, val, err := RunVcgencmd(ctx, "measure_temp")
+	if err != nil {
+		return nil, err
+	}
+
+	sm := vcgencmdTempRE.FindStringSubmatch(val)
+	if len(sm) == 0 {
+		return nil, fmt.Errorf("cannot parse temperature")
+	}
+
+	temp, err := strconv.ParseFloat(sm[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert temperature: %w", err)
+	}
+
+	return []Reading{{Sensor: "cpu_thermal", Celsius: temp}}, nil
+}
+
+// Sensors combines several backends.
+type Sensors struct {
+	Backends []Backend
+}
+
+// New returns Sensors reading the sysfs tree at sysRoot first and falling
+// back to vcgencmd if it's installed.
+func New(sysRoot string) *Sensors {
+	s := &Sensors{
+		Backends: []Backend{
+			ThermalZones{Root: sysRoot},
+			Hwmon{Root: sysRoot},
+		},
+	}
+	if _, err := exec.LookPath("vcgencmd"); err == nil {
+		s.Backends = append(s.Backends, Vcgencmd{})
+	}
+	return s
+}
+
+// Temperatures returns the readings of every backend, backends that fail
+// are skipped.
+func (s *Sensors) Temperatures(ctx context.Context) []Reading {
+	rs := make([]Reading, 0)
+	for _, b := range s.Backends {
+		brs, err := b.Temperatures(ctx)
+		if err != nil {
+			continue
+		}
+		for _, r := range brs {
+			r.Sensor = b.Name() + "/" + r.Sensor
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+func isCPUSensor(name string) bool {
+	for _, n := range cpuSensorNames {
+		if name == n || strings.HasPrefix(name, n+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CPUTemperature returns the temperature of the CPU from the first backend
+// that has a reading for it. If none of them knows which sensor is the CPU
+// the first reading is used.
+func (s *Sensors) CPUTemperature(ctx context.Context) (float64, error) {
+	var (
+		fallback *Reading
+		errs     []string
+	)
+
+	for _, b := range s.Backends {
+		rs, err := b.Temperatures(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", b.Name(), err.Error()))
+			continue
+		}
+
+		for i, r := range rs {
+			if isCPUSensor(r.Sensor) {
+				return r.Celsius, nil
+			}
+			if fallback == nil {
+				fallback = &rs[i]
+			}
+		}
+	}
+
+	if fallback != nil {
+		return fallback.Celsius, nil
+	}
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("%w (%s)", ErrNoReadings, strings.Join(errs, ", "))
+	}
+	return 0, ErrNoReadings
+}
@@ -0,0 +1,92 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for p, c := range files {
+		full := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type failingBackend struct{}
+
+func (fb failingBackend) Name() string {
+	return "failing"
+}
+
+func (fb failingBackend) Temperatures(ctx context.Context) ([]Reading, error) {
+	return nil, errors.New("broken")
+}
+
+func TestSensors_Sysfs(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"class/thermal/thermal_zone0/type": "gpu-thermal\n",
+		"class/thermal/thermal_zone0/temp": "41000\n",
+		"class/thermal/thermal_zone1/type": "cpu-thermal\n",
+		"class/thermal/thermal_zone1/temp": "46250\n",
+		"class/hwmon/hwmon0/name":          "nvme\n",
+		"class/hwmon/hwmon0/temp1_input":   "38850\n",
+		"class/hwmon/hwmon0/temp1_label":   "Composite\n",
+		"class/hwmon/hwmon0/temp2_input":   "broken\n",
+	})
+
+	s := &Sensors{Backends: []Backend{ThermalZones{Root: root}, Hwmon{Root: root}}}
+
+	temp, err := s.CPUTemperature(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp != 46.25 {
+		t.Errorf("CPU temperature is %f but should be 46.25", temp)
+	}
+
+	want := map[string]float64{
+		"thermal/gpu-thermal":  41,
+		"thermal/cpu-thermal":  46.25,
+		"hwmon/nvme/Composite": 38.85,
+	}
+	rs := s.Temperatures(context.Background())
+	if len(rs) != len(want) {
+		t.Fatalf("got readings %v but should be %v", rs, want)
+	}
+	for _, r := range rs {
+		if w, ok := want[r.Sensor]; !ok || w != r.Celsius {
+			t.Errorf("unexpected reading %v", r)
+		}
+	}
+}
+
+func TestSensors_Fallback(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"class/hwmon/hwmon3/name":        "acpitz\n",
+		"class/hwmon/hwmon3/temp1_input": "52000\n",
+	})
+
+	s := &Sensors{Backends: []Backend{failingBackend{}, ThermalZones{Root: root}, Hwmon{Root: root}}}
+	temp, err := s.CPUTemperature(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp != 52 {
+		t.Errorf("CPU temperature is %f but should fall back to 52", temp)
+	}
+
+	s = &Sensors{Backends: []Backend{failingBackend{}, ThermalZones{Root: t.TempDir()}}}
+	if _, err := s.CPUTemperature(context.Background()); !errors.Is(err, ErrNoReadings) {
+		t.Errorf("error is %v but should be ErrNoReadings", err)
+	}
+}
@@ -1,12 +1,22 @@
 package series
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"sync"
 	"time"
 
 	"gonum.org/v1/plot/plotter"
 )
 
+var (
+	// ErrOutOfOrder is returned for a datapoint older than the latest one,
+	// e.g. when a Pi without RTC boots with its clock behind the history.
+	ErrOutOfOrder = errors.New("datapoint is older than the latest one")
+)
+
 // type Datapoint struct {
 // 	Timestamp time.Time
 // 	Value     float64
@@ -16,62 +26,126 @@ import (
 // 	fmt.Printf("%s: %f\n", d.Timestamp, d.Value)
 // }
 
+// Datapoints is a fixed size circular buffer of datapoints, ordered by the
+// time they were pushed. It is safe for concurrent use, everything it returns
+// is a copy that stays stable while the buffer keeps moving.
 type Datapoints struct {
+	mu       sync.RWMutex
 	values   plotter.XYs
+	start    int
 	Capacity int
 }
 
+// at returns the i-th oldest datapoint, d.mu must be held.
+func (d *Datapoints) at(i int) plotter.XY {
+	return d.values[(d.start+i)%len(d.values)]
+}
+
+// slice copies the datapoints from the i-th to the j-th oldest, d.mu must be
+// held.
+func (d *Datapoints) slice(i, j int) plotter.XYs {
+	xys := make(plotter.XYs, 0, j-i)
+	for ; i < j; i++ {
+		xys = append(xys, d.at(i))
+	}
+	return xys
+}
+
 func (d *Datapoints) PushXY(xy plotter.XY) error {
-	if len(d.values) == d.Capacity {
-		d.values = d.values[1:d.Capacity]
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Capacity <= 0 {
+		return nil
+	}
+	// Between relies on the datapoints being sorted
+	if len(d.values) > 0 && xy.X < d.at(len(d.values)-1).X {
+		return ErrOutOfOrder
 	}
 
-	d.values = append(d.values, xy)
+	if len(d.values) < d.Capacity {
+		d.values = append(d.values, xy)
+		return nil
+	}
+
+	d.values[d.start] = xy
+	d.start = (d.start + 1) % len(d.values)
 
 	return nil
 }
 
 func (d *Datapoints) Push(ts time.Time, v float64) error {
-	d.PushXY(plotter.XY{float64(ts.Unix()), v})
-	return nil
+	return d.PushXY(plotter.XY{X: float64(ts.Unix()), Y: v})
+}
+
+func (d *Datapoints) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return len(d.values)
 }
 
 func (d *Datapoints) All() plotter.XYs {
-	return d.values
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.slice(0, len(d.values))
 }
 
 func (d *Datapoints) Last(n int) plotter.XYs {
-	if n < 0 {
-		return d.values
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if n < 0 || len(d.values) <= n {
+		return d.slice(0, len(d.values))
 	}
-	if n == 0 {
+	return d.slice(len(d.values)-n, len(d.values))
+}
+
+// Between returns the datapoints with from <= X <= to.
+func (d *Datapoints) Between(from, to float64) plotter.XYs {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	n := len(d.values)
+	i := sort.Search(n, func(i int) bool { return d.at(i).X >= from })
+	j := sort.Search(n, func(i int) bool { return d.at(i).X > to })
+	if j < i {
 		return plotter.XYs{}
 	}
 
-	if len(d.values) <= n {
-		return d.values
-	}
-	return d.values[len(d.values)-n:]
+	return d.slice(i, j)
 }
 
 func (d *Datapoints) Earliest() plotter.XY {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	if len(d.values) == 0 {
 		return plotter.XY{}
 	}
 
-	return d.values[0]
+	return d.at(0)
 }
 
 func (d *Datapoints) Latest() plotter.XY {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	if len(d.values) == 0 {
 		return plotter.XY{}
 	}
-	return d.values[len(d.values)-1]
+	return d.at(len(d.values) - 1)
 }
 
 type Series struct {
 	Name       string
 	Datapoints *Datapoints
+	Rollups    []*Rollup
+
+	mu       sync.Mutex
+	store    Store
+	appended map[string]int
 }
 
 func NewSeries(name string, capacity int) *Series {
@@ -83,9 +157,154 @@ func NewSeries(name string, capacity int) *Series {
 		Capacity: capacity,
 	}
 
+	for _, t := range DefaultTiers {
+		s.Rollups = append(s.Rollups, newRollup(t))
+	}
+
 	return s
 }
 
+// persisted returns every set of datapoints of the series that is written
+// to the Store, keyed by the name it is stored under.
+func (s *Series) persisted() map[string]*Datapoints {
+	p := map[string]*Datapoints{s.Name: s.Datapoints}
+	for _, r := range s.Rollups {
+		for agg, dp := range r.aggregates() {
+			p[fmt.Sprintf("%s@%s.%s", s.Name, r.Name, agg)] = dp
+		}
+	}
+	return p
+}
+
+// Persist loads the datapoints kept in st for this series and appends every
+// datapoint pushed from now on to st. A nil st stops persisting the series.
+func (s *Series) Persist(st Store) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appended = make(map[string]int)
+	if st == nil {
+		s.store = nil
+		return nil
+	}
+
+	for n, dp := range s.persisted() {
+		xys, err := st.Load(n)
+		if err != nil {
+			return fmt.Errorf("cannot load series %s: %w", n, err)
+		}
+
+		for _, xy := range xys {
+			dp.PushXY(xy)
+		}
+		s.appended[n] = len(xys)
+	}
+	s.store = st
+
+	return nil
+}
+
+// Push adds a datapoint to the series, its rollups and to its Store if the
+// series is persisted. Once the Store holds twice the capacity of a set of
+// datapoints it gets compacted down to the datapoints that are still in
+// memory.
+func (s *Series) Push(ts time.Time, v float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Datapoints.Push(ts, v); err != nil {
+		return fmt.Errorf("cannot push %s at %s: %w", s.Name, ts.Format(time.RFC3339), err)
+	}
+
+	changed := map[string]*Datapoints{s.Name: s.Datapoints}
+	for _, r := range s.Rollups {
+		if !r.add(ts, v) {
+			continue
+		}
+		for agg, dp := range r.aggregates() {
+			changed[fmt.Sprintf("%s@%s.%s", s.Name, r.Name, agg)] = dp
+		}
+	}
+
+	if s.store == nil {
+		return nil
+	}
+
+	for n, dp := range changed {
+		if err := s.store.Append(n, dp.Latest()); err != nil {
+			return fmt.Errorf("cannot persist series %s: %w", n, err)
+		}
+		s.appended[n]++
+
+		if s.appended[n] >= 2*dp.Capacity {
+			if err := s.compact(n, dp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Series) compact(n string, dp *Datapoints) error {
+	xys := dp.All()
+	if err := s.store.Compact(n, xys); err != nil {
+		return fmt.Errorf("cannot compact series %s: %w", n, err)
+	}
+	s.appended[n] = len(xys)
+
+	return nil
+}
+
+func (s *Series) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store == nil {
+		return nil
+	}
+
+	for n, dp := range s.persisted() {
+		if err := s.compact(n, dp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range returns the datapoints between from and to. They are taken from the
+// finest resolution that still reaches back to from, so long ranges are
+// served from the rollups. Raw datapoints are returned as they are, agg
+// selects which aggregate of a rollup is returned.
+func (s *Series) Range(from, to time.Time, agg Aggregate) plotter.XYs {
+	dp := s.Datapoints
+	earliest := dp.Earliest().X
+	if dp.Len() == 0 {
+		earliest = math.Inf(1)
+	}
+
+	for _, r := range s.Rollups {
+		if earliest <= float64(from.Unix()) {
+			break
+		}
+
+		rdp := r.Aggregate(agg)
+		if rdp.Len() == 0 {
+			continue
+		}
+		if e := rdp.Earliest().X; e < earliest {
+			dp = rdp
+			earliest = e
+		}
+	}
+
+	return dp.Between(float64(from.Unix()), float64(to.Unix()))
+}
+
+// Since returns the datapoints from from until now, see Range.
+func (s *Series) Since(from time.Time, agg Aggregate) plotter.XYs {
+	return s.Range(from, time.Now(), agg)
+}
+
 func (s *Series) Print() {
 	fmt.Println()
 	for i, dp := range s.Datapoints.All() {
@@ -1,20 +1,24 @@
 package series
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
+
+	"gonum.org/v1/plot/plotter"
 )
 
 func TestSeries_AddDatapoint(t *testing.T) {
 	type fields struct {
 		Name          string
-		Datapoints    []Datapoint
+		Datapoints    plotter.XYs
 		maxDatapoints int
 	}
 	type args struct {
-		dp Datapoint
+		dp plotter.XY
 	}
 	capacities := []int{1, 3, 4, 6, 19}
 	for _, c := range capacities {
@@ -26,7 +30,7 @@ func TestSeries_AddDatapoint(t *testing.T) {
 		for i := 0; i < c*2; i++ {
 			v := rand.Float64()
 			ts := tsStart.Add(time.Duration(float64(i)) * time.Second)
-			s.Datapoints.Push(Datapoint{ts, v})
+			s.Datapoints.Push(ts, v)
 
 			var idx int
 			if i < c {
@@ -38,12 +42,95 @@ func TestSeries_AddDatapoint(t *testing.T) {
 			s.Print()
 			d := s.Datapoints.Latest()
 
-			if d.Value != v {
-				t.Errorf("iteration %d: value [%d] is %f but should be %f", i, idx, d.Value, v)
+			if d.Y != v {
+				t.Errorf("iteration %d: value [%d] is %f but should be %f", i, idx, d.Y, v)
+			}
+			if d.X != float64(ts.Unix()) {
+				t.Errorf("iteration %d: timestamp [%d] is %.0f but should be %d", i, idx, d.X, ts.Unix())
 			}
-			if !d.Timestamp.Equal(ts) {
-				t.Errorf("iteration %d: timestamp [%d] is %s but should be %s", i, idx, d.Timestamp, ts)
+		}
+	}
+}
+
+func TestDatapoints_Concurrent(t *testing.T) {
+	s := NewSeries("test-concurrent", 100)
+	tsStart := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Push(tsStart.Add(time.Duration(i)*time.Second), float64(w))
 			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				xys := s.Datapoints.Last(50)
+				if len(xys) > 50 {
+					t.Errorf("Last(50) returned %d datapoints", len(xys))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := s.Datapoints.Len(); n != 100 {
+		t.Errorf("buffer holds %d datapoints but should be 100", n)
+	}
+}
+
+func TestDatapoints_LastIsCopy(t *testing.T) {
+	d := &Datapoints{Capacity: 3}
+	tsStart := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		d.Push(tsStart.Add(time.Duration(i)*time.Second), float64(i))
+	}
+
+	last := d.Last(3)
+	d.Push(tsStart.Add(3*time.Second), 3)
+
+	for i, xy := range last {
+		if xy.Y != float64(i) {
+			t.Errorf("value [%d] changed to %f after a push", i, xy.Y)
+		}
+	}
+	if l := d.Last(-1); l[0].Y != 1 || l[2].Y != 3 {
+		t.Errorf("buffer did not wrap around: %v", l)
+	}
+}
+
+func TestSeries_ClockBackwards(t *testing.T) {
+	s := NewSeries("test-clock", 10)
+	start := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := s.Push(start.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
 		}
 	}
+
+	// booted with the clock behind the saved history
+	if err := s.Push(start.Add(-time.Hour), 42); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("expected %v, got %v", ErrOutOfOrder, err)
+	}
+	if s.Datapoints.Len() != 5 {
+		t.Errorf("datapoint should be dropped, got %d datapoints", s.Datapoints.Len())
+	}
+
+	got := s.Datapoints.Between(float64(start.Add(2*time.Second).Unix()), float64(start.Add(3*time.Second).Unix()))
+	if len(got) != 2 || got[0].Y != 2 || got[1].Y != 3 {
+		t.Errorf("unexpected range %v", got)
+	}
+
+	// the same second is fine
+	if err := s.Push(start.Add(4*time.Second), 4.5); err != nil {
+		t.Error(err)
+	}
 }
@@ -0,0 +1,168 @@
+package series
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// Aggregate selects which value of a rollup bucket is returned.
+type Aggregate string
+
+const (
+	Min Aggregate = "min"
+	Avg Aggregate = "avg"
+	Max Aggregate = "max"
+)
+
+// Tier describes a rollup of a series into buckets of Resolution. Capacity
+// buckets are kept, so a tier covers Capacity*Resolution.
+type Tier struct {
+	Name       string
+	Resolution time.Duration
+	Capacity   int
+}
+
+var (
+	// DefaultTiers are added to every new series, finest first. Each one
+	// keeps about 3000 buckets, enough for a plot of the range it covers,
+	// so the rollups of a series take about 300KB next to its raw
+	// datapoints.
+	DefaultTiers = []Tier{
+		{Name: "1m", Resolution: time.Minute, Capacity: 24 * 60},
+		{Name: "10m", Resolution: 10 * time.Minute, Capacity: 14 * 24 * 6},
+		{Name: "3h", Resolution: 3 * time.Hour, Capacity: 365 * 8},
+	}
+)
+
+// Rollup keeps min, avg and max of every bucket of its Tier.
+type Rollup struct {
+	Tier
+	Min, Avg, Max *Datapoints
+
+	bucket   time.Time
+	count    int
+	sum      float64
+	min, max float64
+}
+
+func newRollup(t Tier) *Rollup {
+	return &Rollup{
+		Tier: t,
+		Min:  &Datapoints{Capacity: t.Capacity},
+		Avg:  &Datapoints{Capacity: t.Capacity},
+		Max:  &Datapoints{Capacity: t.Capacity},
+	}
+}
+
+func (r *Rollup) aggregates() map[Aggregate]*Datapoints {
+	return map[Aggregate]*Datapoints{
+		Min: r.Min,
+		Avg: r.Avg,
+		Max: r.Max,
+	}
+}
+
+// Aggregate returns the datapoints of agg, anything unknown returns Avg.
+func (r *Rollup) Aggregate(agg Aggregate) *Datapoints {
+	switch agg {
+	case Min:
+		return r.Min
+	case Max:
+		return r.Max
+	default:
+		return r.Avg
+	}
+}
+
+// add accumulates v into the current bucket. When ts belongs to a newer
+// bucket the current one is closed and its aggregates are pushed, in that
+// case add returns true. Datapoints older than the current bucket are
+// dropped.
+func (r *Rollup) add(ts time.Time, v float64) bool {
+	b := ts.Truncate(r.Resolution)
+	closed := false
+	if b.Before(r.bucket) {
+		return false
+	}
+
+	if !b.Equal(r.bucket) {
+		if r.count > 0 {
+			r.Min.Push(r.bucket, r.min)
+			r.Avg.Push(r.bucket, r.sum/float64(r.count))
+			r.Max.Push(r.bucket, r.max)
+			closed = true
+		}
+
+		r.bucket = b
+		r.count = 0
+		r.sum = 0
+		r.min = math.Inf(1)
+		r.max = math.Inf(-1)
+	}
+
+	r.count++
+	r.sum += v
+	r.min = math.Min(r.min, v)
+	r.max = math.Max(r.max, v)
+
+	return closed
+}
+
+// Resample aggregates xys into buckets of step, each bucket is placed at its
+// start. A step of 0 or less returns xys unchanged.
+func Resample(xys plotter.XYs, step time.Duration, agg Aggregate) plotter.XYs {
+	secs := step.Seconds()
+	if secs <= 0 || len(xys) == 0 {
+		return xys
+	}
+
+	resampled := make(plotter.XYs, 0)
+	bucket, count, v := math.NaN(), 0, 0.0
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		if agg != Min && agg != Max {
+			v /= float64(count)
+		}
+		resampled = append(resampled, plotter.XY{X: bucket, Y: v})
+	}
+
+	for _, xy := range xys {
+		b := math.Floor(xy.X/secs) * secs
+		if b != bucket {
+			flush()
+			bucket, count, v = b, 0, 0
+		}
+
+		switch {
+		case count == 0:
+			v = xy.Y
+		case agg == Min:
+			v = math.Min(v, xy.Y)
+		case agg == Max:
+			v = math.Max(v, xy.Y)
+		default:
+			v += xy.Y
+		}
+		count++
+	}
+	flush()
+
+	return resampled
+}
+
+func ParseAggregate(s string) (Aggregate, error) {
+	switch a := Aggregate(s); a {
+	case Min, Avg, Max:
+		return a, nil
+	case "":
+		return Avg, nil
+	default:
+		return "", fmt.Errorf("unknown aggregate %q", s)
+	}
+}
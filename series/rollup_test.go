@@ -0,0 +1,92 @@
+package series
+
+import (
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestSeries_Rollup(t *testing.T) {
+	s := NewSeries("test-rollup", 60)
+	tsStart := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+
+	// three minutes worth of datapoints, the value is the minute
+	for i := 0; i < 180; i++ {
+		s.Push(tsStart.Add(time.Duration(i)*time.Second), float64(i/60))
+	}
+	// closes the third bucket
+	s.Push(tsStart.Add(3*time.Minute), 3)
+
+	r := s.Rollups[0]
+	avg := r.Avg.All()
+	if len(avg) != 3 {
+		t.Fatalf("rollup has %d buckets but should have 3", len(avg))
+	}
+	for i, xy := range avg {
+		if xy.X != float64(tsStart.Add(time.Duration(i)*time.Minute).Unix()) {
+			t.Errorf("bucket [%d] starts at %.0f", i, xy.X)
+		}
+		if xy.Y != float64(i) {
+			t.Errorf("bucket [%d] avg is %f but should be %d", i, xy.Y, i)
+		}
+	}
+
+	// the raw datapoints only reach back one minute, so a range over
+	// two minutes must come from the rollup
+	now := tsStart.Add(3 * time.Minute)
+	if got := s.Range(now.Add(-30*time.Second), now, Avg); len(got) != 31 {
+		t.Errorf("short range returned %d datapoints but should be 31 raw ones", len(got))
+	}
+	if got := s.Range(now.Add(-2*time.Minute), now, Max); len(got) != 2 {
+		t.Errorf("long range returned %d datapoints but should be 2 buckets", len(got))
+	}
+}
+
+func TestResample(t *testing.T) {
+	xys := plotter.XYs{
+		{X: 0, Y: 1}, {X: 30, Y: 3}, {X: 59, Y: 2},
+		{X: 60, Y: 10},
+		{X: 180, Y: 4}, {X: 200, Y: 6},
+	}
+
+	tests := []struct {
+		agg  Aggregate
+		want plotter.XYs
+	}{
+		{Avg, plotter.XYs{{X: 0, Y: 2}, {X: 60, Y: 10}, {X: 180, Y: 5}}},
+		{Min, plotter.XYs{{X: 0, Y: 1}, {X: 60, Y: 10}, {X: 180, Y: 4}}},
+		{Max, plotter.XYs{{X: 0, Y: 3}, {X: 60, Y: 10}, {X: 180, Y: 6}}},
+	}
+	for _, tt := range tests {
+		got := Resample(xys, time.Minute, tt.agg)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %d buckets but should be %d", tt.agg, len(got), len(tt.want))
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: bucket [%d] is %v but should be %v", tt.agg, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRollup_ClockBackwards(t *testing.T) {
+	r := newRollup(Tier{Name: "1m", Resolution: time.Minute, Capacity: 10})
+	start := time.Date(2021, time.September, 9, 11, 5, 0, 0, time.UTC)
+
+	r.add(start, 1)
+	r.add(start.Add(10*time.Second), 3)
+	// an earlier minute neither closes the bucket nor counts for it
+	if r.add(start.Add(-2*time.Minute), 100) {
+		t.Fatal("bucket closed by an older datapoint")
+	}
+	if !r.add(start.Add(time.Minute), 5) {
+		t.Fatal("bucket should be closed by the next minute")
+	}
+
+	avg := r.Avg.All()
+	if len(avg) != 1 || avg[0].X != float64(start.Unix()) || avg[0].Y != 2 {
+		t.Errorf("unexpected buckets %v", avg)
+	}
+}
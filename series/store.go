@@ -0,0 +1,255 @@
+package series
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// Store persists the datapoints of a Series so they survive restarts.
+type Store interface {
+	// Load returns all datapoints stored for name, oldest first.
+	Load(name string) (plotter.XYs, error)
+	// Append adds a single datapoint to the end of name.
+	Append(name string, xy plotter.XY) error
+	// Compact replaces everything stored for name with xys.
+	Compact(name string, xys plotter.XYs) error
+	// Close flushes and releases all resources held by the Store.
+	Close() error
+}
+
+// Every record in a segment file is the X and Y value as little endian
+// float64 followed by the CRC32 of those 16 bytes. A record that was only
+// partially written (e.g. after a power loss) fails the checksum and is cut
+// off when the segment is loaded.
+const (
+	recordPayloadSize = 16
+	recordSize        = recordPayloadSize + 4
+	segmentExtension  = ".dat"
+)
+
+var (
+	ErrClosed = errors.New("store is closed")
+)
+
+// FileStore is a Store that keeps one append-only segment file per series
+// in a directory.
+type FileStore struct {
+	dir      string
+	mu       sync.Mutex
+	segments map[string]*os.File
+	closed   bool
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create series directory: %w", err)
+	}
+
+	return &FileStore{
+		dir:      dir,
+		segments: make(map[string]*os.File),
+	}, nil
+}
+
+// path returns the segment file of name. The name is escaped so that it
+// can be recovered from the file name, see Names.
+func (fs *FileStore) path(name string) string {
+	return filepath.Join(fs.dir, url.PathEscape(name)+segmentExtension)
+}
+
+// Names returns the names of all segments in the store, sorted.
+func (fs *FileStore) Names() ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	es, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list series directory: %w", err)
+	}
+
+	names := make([]string, 0, len(es))
+	for _, e := range es {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExtension) {
+			continue
+		}
+		n, err := url.PathUnescape(strings.TrimSuffix(e.Name(), segmentExtension))
+		if err != nil {
+			continue
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func encodeRecord(buf []byte, xy plotter.XY) {
+	binary.LittleEndian.PutUint64(buf[0:8], math.Float64bits(xy.X))
+	binary.LittleEndian.PutUint64(buf[8:16], math.Float64bits(xy.Y))
+	binary.LittleEndian.PutUint32(buf[16:20], crc32.ChecksumIEEE(buf[:recordPayloadSize]))
+}
+
+func decodeRecord(buf []byte) (plotter.XY, bool) {
+	if crc32.ChecksumIEEE(buf[:recordPayloadSize]) != binary.LittleEndian.Uint32(buf[16:20]) {
+		return plotter.XY{}, false
+	}
+
+	return plotter.XY{
+		X: math.Float64frombits(binary.LittleEndian.Uint64(buf[0:8])),
+		Y: math.Float64frombits(binary.LittleEndian.Uint64(buf[8:16])),
+	}, true
+}
+
+// Load reads the segment file of name. Reading stops at the first damaged
+// record and the file is truncated to the last intact one, so that later
+// appends don't end up behind garbage.
+func (fs *FileStore) Load(name string) (plotter.XYs, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	p := fs.path(name)
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return plotter.XYs{}, nil
+		}
+		return plotter.XYs{}, err
+	}
+
+	xys := make(plotter.XYs, 0, len(buf)/recordSize)
+	valid := 0
+	for ; valid+recordSize <= len(buf); valid += recordSize {
+		xy, ok := decodeRecord(buf[valid : valid+recordSize])
+		if !ok {
+			break
+		}
+		xys = append(xys, xy)
+	}
+
+	if valid != len(buf) {
+		if err := os.Truncate(p, int64(valid)); err != nil {
+			return xys, fmt.Errorf("cannot truncate damaged segment %s: %w", p, err)
+		}
+	}
+
+	return xys, nil
+}
+
+// segment returns the open segment file of name, fs.mu must be held.
+func (fs *FileStore) segment(name string) (*os.File, error) {
+	if fs.closed {
+		return nil, ErrClosed
+	}
+	if f, ok := fs.segments[name]; ok {
+		return f, nil
+	}
+
+	f, err := os.OpenFile(fs.path(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.segments[name] = f
+	return f, nil
+}
+
+func (fs *FileStore) Append(name string, xy plotter.XY) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := fs.segment(name)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, recordSize)
+	encodeRecord(buf, xy)
+	_, err = f.Write(buf)
+	return err
+}
+
+// Compact writes xys to a temporary file and atomically replaces the
+// segment of name with it.
+func (fs *FileStore) Compact(name string, xys plotter.XYs) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+	p := fs.path(name)
+	tmp, err := os.CreateTemp(fs.dir, filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buf := make([]byte, recordSize*len(xys))
+	for i, xy := range xys {
+		encodeRecord(buf[i*recordSize:(i+1)*recordSize], xy)
+	}
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if f, ok := fs.segments[name]; ok {
+		f.Close()
+		delete(fs.segments, name)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+
+	return syncDir(fs.dir)
+}
+
+// Close syncs and closes all segment files. Appending to a closed store
+// fails with ErrClosed.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.closed = true
+	var firstErr error
+	for n, f := range fs.segments {
+		if err := f.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(fs.segments, n)
+	}
+
+	return firstErr
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
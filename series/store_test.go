@@ -0,0 +1,155 @@
+package series
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestFileStore_PersistRestore(t *testing.T) {
+	dir := t.TempDir()
+	tsStart := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+
+	st, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSeries("test.persist", 5)
+	if err := s.Persist(st); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		if err := s.Push(tsStart.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	r := NewSeries("test.persist", 5)
+	if err := r.Persist(st); err != nil {
+		t.Fatal(err)
+	}
+
+	all := r.Datapoints.All()
+	if len(all) != 5 {
+		t.Fatalf("restored %d datapoints but should be 5", len(all))
+	}
+	for i, xy := range all {
+		if xy.Y != float64(7+i) {
+			t.Errorf("value [%d] is %f but should be %d", i, xy.Y, 7+i)
+		}
+	}
+}
+
+func TestFileStore_PartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	tsStart := time.Date(2021, time.September, 9, 11, 0, 0, 0, time.UTC)
+
+	st, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSeries("test.partial", 10)
+	if err := s.Persist(st); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Push(tsStart.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	st.Close()
+
+	// simulate a record that was only half written when the power went out
+	p := filepath.Join(dir, "test.partial"+segmentExtension)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+	f.Close()
+
+	st, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	xys, err := st.Load("test.partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xys) != 3 {
+		t.Fatalf("loaded %d datapoints but should be 3", len(xys))
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 3*recordSize {
+		t.Errorf("segment is %d bytes but should have been truncated to %d", fi.Size(), 3*recordSize)
+	}
+}
+
+func TestFileStore_Closed(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Append("test.closed", plotter.XY{X: 1, Y: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is reopened behind the back of whoever closed it
+	if err := st.Append("test.closed", plotter.XY{X: 2, Y: 2}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+	if err := st.Compact("test.closed", plotter.XYs{{X: 2, Y: 2}}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+	if len(st.segments) != 0 {
+		t.Errorf("expected no open segments, got %d", len(st.segments))
+	}
+}
+
+func TestFileStore_Names(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	want := []string{`diskUsage.data\x5fx`, "diskUsage.data_x", "network.eth0.100.rx@1m.avg", "odd/name 100%"}
+	for _, n := range want {
+		if err := st.Append(n, plotter.XY{X: 1, Y: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// left over from an interrupted compaction
+	os.WriteFile(filepath.Join(dir, "cpuTemp.dat.123.tmp"), nil, 0644)
+
+	names, err := st.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,189 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+)
+
+// Sample is a single value collected for a series.
+type Sample struct {
+	Series *series.Series
+	Value  float64
+}
+
+// Collector gathers samples for one or more series. Collect is called every
+// Interval by the Registry.
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) ([]Sample, error)
+}
+
+// TimeoutCollector is implemented by collectors that need a different
+// timeout than their interval.
+type TimeoutCollector interface {
+	Timeout() time.Duration
+}
+
+// CollectorStatus records the outcome of the runs of a collector.
+type CollectorStatus struct {
+	Name                string        `json:"name"`
+	Interval            time.Duration `json:"interval"`
+	LastRun             time.Time     `json:"lastRun"`
+	LastSuccess         time.Time     `json:"lastSuccess"`
+	LastError           string        `json:"lastError,omitempty"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	TotalFailures       int           `json:"totalFailures"`
+	Running             bool          `json:"running"`
+}
+
+type registeredCollector struct {
+	Collector
+	next   time.Time
+	status CollectorStatus
+}
+
+// timeout is the interval of the collector unless it asks for a different
+// one. Both may change while the collector is registered.
+func (rc *registeredCollector) timeout() time.Duration {
+	if tc, ok := rc.Collector.(TimeoutCollector); ok {
+		return tc.Timeout()
+	}
+	return rc.Interval()
+}
+
+// Registry runs every registered collector once its interval has passed.
+// A collector that fails, panics or times out only affects its own status.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []*registeredCollector
+	running    sync.WaitGroup
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, &registeredCollector{
+		Collector: c,
+		status: CollectorStatus{
+			Name:     c.Name(),
+			Interval: c.Interval(),
+		},
+	})
+}
+
+// Tick starts every collector that is due at t and isn't still busy with
+// its previous run.
+func (r *Registry) Tick(ctx context.Context, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rc := range r.collectors {
+		if rc.status.Running || t.Before(rc.next) {
+			continue
+		}
+
+		rc.next = t.Add(rc.Interval()).Truncate(rc.Interval())
+		rc.status.Interval = rc.Interval()
+		rc.status.Running = true
+		rc.status.LastRun = t
+
+		r.running.Add(1)
+		go r.run(ctx, rc, t, rc.timeout())
+	}
+}
+
+type collectResult struct {
+	samples []Sample
+	err     error
+}
+
+func (r *Registry) run(ctx context.Context, rc *registeredCollector, t time.Time, timeout time.Duration) {
+	defer r.running.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the collector itself is waited for as well, it may still be holding
+	// on to files or subprocesses after the timeout
+	res := make(chan collectResult, 1)
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		defer func() {
+			if p := recover(); p != nil {
+				res <- collectResult{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+
+		s, err := rc.Collect(ctx)
+		res <- collectResult{samples: s, err: err}
+	}()
+
+	var cr collectResult
+	select {
+	case cr = <-res:
+	case <-ctx.Done():
+		r.finish(rc, t, fmt.Errorf("timed out after %s", timeout))
+		// the collector stays running until it returns, so it's never
+		// collecting twice at once; what it returns that late is dropped
+		<-res
+		r.mu.Lock()
+		rc.status.Running = false
+		r.mu.Unlock()
+		return
+	}
+
+	for _, s := range cr.samples {
+		if err := s.Series.Push(t, s.Value); err != nil && cr.err == nil {
+			cr.err = err
+		}
+	}
+
+	r.finish(rc, t, cr.err)
+	r.mu.Lock()
+	rc.status.Running = false
+	r.mu.Unlock()
+}
+
+// finish records the outcome of the run of rc started at t.
+func (r *Registry) finish(rc *registeredCollector, t time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		rc.status.LastError = err.Error()
+		rc.status.ConsecutiveFailures++
+		rc.status.TotalFailures++
+		return
+	}
+	rc.status.LastError = ""
+	rc.status.ConsecutiveFailures = 0
+	rc.status.LastSuccess = t
+}
+
+// Wait blocks until every collector that has been started by Tick has
+// returned.
+func (r *Registry) Wait() {
+	r.running.Wait()
+}
+
+// Status returns the status of every registered collector, sorted by name.
+func (r *Registry) Status() []CollectorStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := make([]CollectorStatus, len(r.collectors))
+	for i, rc := range r.collectors {
+		st[i] = rc.status
+	}
+	sort.Slice(st, func(i, j int) bool { return st[i].Name < st[j].Name })
+
+	return st
+}
@@ -0,0 +1,122 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+)
+
+type testCollector struct {
+	name    string
+	timeout time.Duration
+	collect func(ctx context.Context) ([]Sample, error)
+	calls   int32
+}
+
+func (c *testCollector) Name() string            { return c.name }
+func (c *testCollector) Interval() time.Duration { return time.Second }
+func (c *testCollector) Timeout() time.Duration  { return c.timeout }
+
+func (c *testCollector) Collect(ctx context.Context) ([]Sample, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.collect(ctx)
+}
+
+func statusOf(r *Registry, name string) CollectorStatus {
+	for _, st := range r.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	return CollectorStatus{}
+}
+
+func TestRegistry_Isolation(t *testing.T) {
+	s := series.NewSeries("test", 10)
+	ok := &testCollector{name: "ok", timeout: time.Second, collect: func(ctx context.Context) ([]Sample, error) {
+		return []Sample{{Series: s, Value: 42}}, nil
+	}}
+	failing := &testCollector{name: "failing", timeout: time.Second, collect: func(ctx context.Context) ([]Sample, error) {
+		return nil, errors.New("no such file")
+	}}
+	panicking := &testCollector{name: "panicking", timeout: time.Second, collect: func(ctx context.Context) ([]Sample, error) {
+		panic("index out of range")
+	}}
+
+	r := new(Registry)
+	for _, c := range []Collector{ok, failing, panicking} {
+		r.Register(c)
+	}
+	now := time.Now()
+	r.Tick(context.Background(), now)
+	r.Wait()
+
+	if st := statusOf(r, "ok"); st.LastError != "" || !st.LastSuccess.Equal(now) || st.Running {
+		t.Errorf("unexpected status of the working collector %+v", st)
+	}
+	if s.Datapoints.Latest().Y != 42 {
+		t.Errorf("expected the sample to be pushed, got %v", s.Datapoints.Latest())
+	}
+	if st := statusOf(r, "failing"); st.LastError != "no such file" || st.ConsecutiveFailures != 1 {
+		t.Errorf("unexpected status of the failing collector %+v", st)
+	}
+	if st := statusOf(r, "panicking"); st.LastError != "panic: index out of range" || st.TotalFailures != 1 || st.Running {
+		t.Errorf("unexpected status of the panicking collector %+v", st)
+	}
+
+	// failures are counted until the next success
+	r.Tick(context.Background(), now.Add(time.Second))
+	r.Wait()
+	if st := statusOf(r, "failing"); st.ConsecutiveFailures != 2 || st.TotalFailures != 2 {
+		t.Errorf("expected 2 failures, got %+v", st)
+	}
+}
+
+func TestRegistry_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	hung := &testCollector{name: "hung", timeout: 10 * time.Millisecond, collect: func(ctx context.Context) ([]Sample, error) {
+		// ignores ctx like a stuck subprocess or a dead NFS mount
+		<-release
+		return nil, nil
+	}}
+
+	r := new(Registry)
+	r.Register(hung)
+	now := time.Now()
+	r.Tick(context.Background(), now)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for statusOf(r, "hung").LastError == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st := statusOf(r, "hung")
+	if st.LastError != "timed out after 10ms" || st.ConsecutiveFailures != 1 {
+		t.Fatalf("expected the timeout to be recorded, got %+v", st)
+	}
+	if !st.Running {
+		t.Errorf("collector should be running until Collect returns")
+	}
+
+	// it isn't started again while it's still stuck
+	for i := 1; i <= 3; i++ {
+		r.Tick(context.Background(), now.Add(time.Duration(i)*time.Second))
+	}
+	if n := atomic.LoadInt32(&hung.calls); n != 1 {
+		t.Errorf("expected a single call while stuck, got %d", n)
+	}
+
+	close(release)
+	r.Wait()
+	if st := statusOf(r, "hung"); st.Running || st.ConsecutiveFailures != 1 {
+		t.Errorf("unexpected status after returning %+v", st)
+	}
+	r.Tick(context.Background(), now.Add(4*time.Second))
+	r.Wait()
+	if n := atomic.LoadInt32(&hung.calls); n != 2 {
+		t.Errorf("expected the collector to run again, got %d calls", n)
+	}
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// collectorFunc turns a plain function into a Collector.
+type collectorFunc struct {
+	name     string
+	interval func() time.Duration
+	collect  func(ctx context.Context) ([]Sample, error)
+}
+
+func (c collectorFunc) Name() string {
+	return c.name
+}
+
+func (c collectorFunc) Interval() time.Duration {
+	return c.interval()
+}
+
+func plotInterval() time.Duration {
+	return settings().Plots.UpdateInterval
+}
+
+func (c collectorFunc) Collect(ctx context.Context) ([]Sample, error) {
+	return c.collect(ctx)
+}
+
+var (
+	cpuTemperatureCollector = collectorFunc{
+		name:     "cpuTemp",
+		interval: plotInterval,
+		collect: func(ctx context.Context) ([]Sample, error) {
+			temp, err := CPUTemperature(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []Sample{{CPUTemperaturePlot.Value, temp}}, nil
+		},
+	}
+
+	loadAvgCollector = collectorFunc{
+		name:     "loadAvg",
+		interval: plotInterval,
+		collect: func(ctx context.Context) ([]Sample, error) {
+			avg, err := LoadAvg()
+			if err != nil {
+				return nil, err
+			}
+			return []Sample{
+				{LoadAvgPlot.Avg1, avg.Load1},
+				{LoadAvgPlot.Avg5, avg.Load5},
+				{LoadAvgPlot.Avg15, avg.Load15},
+			}, nil
+		},
+	}
+
+	memoryCollector = collectorFunc{
+		name:     "memoryUsage",
+		interval: plotInterval,
+		collect: func(ctx context.Context) ([]Sample, error) {
+			mi, err := Meminfo()
+			if err != nil {
+				return nil, err
+			}
+			used := (float64(*mi.MemTotal) - float64(*mi.MemFree)) * 100 / float64(*mi.MemTotal)
+			return []Sample{{MemoryUsedPlot.Value, used}}, nil
+		},
+	}
+
+	raidCollector = collectorFunc{
+		name:     "raid",
+		interval: func() time.Duration { return settings().Intervals.Raid },
+		collect: func(ctx context.Context) ([]Sample, error) {
+			return []Sample{}, RAID.Update()
+		},
+	}
+
+	alertCollector = collectorFunc{
+		name:     "alerts",
+		interval: func() time.Duration { return settings().Intervals.AlertEval },
+		collect: func(ctx context.Context) ([]Sample, error) {
+			changed := Alerts.Evaluate(time.Now())
+			for _, a := range changed {
+				log.Printf("alert %s is %s (%s, value %.2f)", a.Rule.Name, a.State, a.Rule.Expr, a.Value)
+			}
+			// failed notifications are sent again with the next evaluation
+			if Notifier != nil && (len(changed) > 0 || Notifier.Pending()) {
+				Notifier.Enqueue(changed)
+			}
+			return []Sample{}, nil
+		},
+	}
+)
@@ -0,0 +1,187 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/prometheus/procfs"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	CPUUsagePlot = newCPUPlot("cpu", "CPU Usage")
+	CPUCorePlots = make([]*CPUPlot, 0)
+
+	cpuStateColors = []color.Color{
+		color.RGBA{43, 89, 195, 255},
+		color.RGBA{211, 101, 130, 255},
+		color.RGBA{232, 141, 103, 255},
+		color.RGBA{144, 186, 173, 255},
+		color.RGBA{113, 124, 137, 255},
+	}
+)
+
+// CPUPlot shows the utilisation of a CPU in percent as a stacked area chart,
+// split by the state the time was spent in.
+type CPUPlot struct {
+	Title  string
+	User   *series.Series
+	System *series.Series
+	IOWait *series.Series
+	IRQ    *series.Series
+	Steal  *series.Series
+}
+
+func newCPUPlot(name, title string) *CPUPlot {
+	return &CPUPlot{
+		Title:  title,
+		User:   series.NewSeries(name+".user", config.PlotDatapoints),
+		System: series.NewSeries(name+".system", config.PlotDatapoints),
+		IOWait: series.NewSeries(name+".iowait", config.PlotDatapoints),
+		IRQ:    series.NewSeries(name+".irq", config.PlotDatapoints),
+		Steal:  series.NewSeries(name+".steal", config.PlotDatapoints),
+	}
+}
+
+// registerCPUPlots adds a plot for every core to AllPlots.
+func registerCPUPlots() error {
+	st, err := proc.Stat()
+	if err != nil {
+		return err
+	}
+
+	for i := range st.CPU {
+		name := fmt.Sprintf("cpu%d", i)
+		cp := newCPUPlot(name, fmt.Sprintf("CPU %d Usage", i))
+		CPUCorePlots = append(CPUCorePlots, cp)
+		AllPlots[name] = cp
+	}
+
+	return nil
+}
+
+func (cp *CPUPlot) Series() []*series.Series {
+	return []*series.Series{cp.User, cp.System, cp.IOWait, cp.IRQ, cp.Steal}
+}
+
+func (cp *CPUPlot) PNG(n int) ([]byte, error) {
+	p := setupPlot(cp.Title, n)
+	p.Y.Min = 0
+	p.Y.Max = 100
+
+	labels := []string{"user", "system", "iowait", "irq", "steal"}
+	layers := make([]plotter.XYs, 0, len(labels))
+	for _, s := range cp.Series() {
+		layers = append(layers, window(s, n))
+	}
+
+	for i, area := range stackedAreas(layers) {
+		poly, err := plotter.NewPolygon(area)
+		if err != nil {
+			return []byte{}, err
+		}
+		poly.Color = cpuStateColors[i%len(cpuStateColors)]
+		poly.LineStyle = draw.LineStyle{}
+
+		p.Add(poly)
+		p.Legend.Add(labels[i], poly)
+	}
+
+	return plotToPng(p)
+}
+
+// stackedAreas stacks every layer on top of the ones before it and returns
+// the outline of each layer as a polygon. Layers are aligned on X, the X
+// values of the first layer are used for all of them.
+func stackedAreas(layers []plotter.XYs) []plotter.XYs {
+	if len(layers) == 0 || len(layers[0]) < 2 {
+		return []plotter.XYs{}
+	}
+
+	xs := layers[0]
+	lower := make([]float64, len(xs))
+	areas := make([]plotter.XYs, 0, len(layers))
+
+	for _, l := range layers {
+		byX := make(map[float64]float64, len(l))
+		for _, xy := range l {
+			byX[xy.X] = xy.Y
+		}
+
+		area := make(plotter.XYs, 2*len(xs))
+		for i, xy := range xs {
+			upper := lower[i] + byX[xy.X]
+			area[i] = plotter.XY{X: xy.X, Y: upper}
+			area[len(area)-1-i] = plotter.XY{X: xy.X, Y: lower[i]}
+			lower[i] = upper
+		}
+		areas = append(areas, area)
+	}
+
+	return areas
+}
+
+// cpuCollector works out the CPU utilisation from the difference between
+// two readings of /proc/stat.
+type cpuCollector struct {
+	prev *procfs.Stat
+}
+
+func (cc *cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (cc *cpuCollector) Interval() time.Duration {
+	return plotInterval()
+}
+
+func cpuSamples(cp *CPUPlot, prev, cur procfs.CPUStat) []Sample {
+	total := func(s procfs.CPUStat) float64 {
+		return s.User + s.Nice + s.System + s.Idle + s.Iowait + s.IRQ + s.SoftIRQ + s.Steal
+	}
+
+	d := total(cur) - total(prev)
+	if d <= 0 {
+		return []Sample{}
+	}
+
+	pct := func(v float64) float64 {
+		return v * 100 / d
+	}
+
+	return []Sample{
+		{cp.User, pct(cur.User + cur.Nice - prev.User - prev.Nice)},
+		{cp.System, pct(cur.System - prev.System)},
+		{cp.IOWait, pct(cur.Iowait - prev.Iowait)},
+		{cp.IRQ, pct(cur.IRQ + cur.SoftIRQ - prev.IRQ - prev.SoftIRQ)},
+		{cp.Steal, pct(cur.Steal - prev.Steal)},
+	}
+}
+
+func (cc *cpuCollector) Collect(ctx context.Context) ([]Sample, error) {
+	st, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := cc.prev
+	cc.prev = &st
+	if prev == nil {
+		return []Sample{}, nil
+	}
+
+	samples := cpuSamples(CPUUsagePlot, prev.CPUTotal, st.CPUTotal)
+	for i, cp := range CPUCorePlots {
+		if i >= len(st.CPU) || i >= len(prev.CPU) {
+			break
+		}
+		samples = append(samples, cpuSamples(cp, prev.CPU[i], st.CPU[i])...)
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestCPUSamples(t *testing.T) {
+	cp := newCPUPlot("test", "Test")
+	prev := procfs.CPUStat{User: 100, Nice: 10, System: 50, Idle: 1000, Iowait: 20, IRQ: 5, SoftIRQ: 5, Steal: 0}
+
+	tests := []struct {
+		name string
+		cur  procfs.CPUStat
+		want []float64
+	}{
+		{
+			name: "busy",
+			// 200 ticks passed: 60 user, 20 nice, 40 system, 50 idle,
+			// 10 iowait, 6 irq, 4 softirq, 10 steal
+			cur:  procfs.CPUStat{User: 160, Nice: 30, System: 90, Idle: 1050, Iowait: 30, IRQ: 11, SoftIRQ: 9, Steal: 10},
+			want: []float64{40, 20, 5, 5, 5},
+		},
+		{
+			name: "idle",
+			cur:  procfs.CPUStat{User: 100, Nice: 10, System: 50, Idle: 1100, Iowait: 20, IRQ: 5, SoftIRQ: 5},
+			want: []float64{0, 0, 0, 0, 0},
+		},
+		{name: "no time passed", cur: prev},
+		{name: "counters reset", cur: procfs.CPUStat{User: 1, Idle: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := cpuSamples(cp, prev, tt.cur)
+			if len(samples) != len(tt.want) {
+				t.Fatalf("expected %d samples, got %v", len(tt.want), samples)
+			}
+			for i, s := range samples {
+				if s.Series != cp.Series()[i] || s.Value != tt.want[i] {
+					t.Errorf("sample [%d] is %s %v but should be %s %v", i, s.Series.Name, s.Value, cp.Series()[i].Name, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStackedAreas(t *testing.T) {
+	user := plotter.XYs{{X: 0, Y: 10}, {X: 1, Y: 20}, {X: 2, Y: 30}}
+	// the collector missed the reading at 1
+	system := plotter.XYs{{X: 0, Y: 5}, {X: 2, Y: 5}}
+
+	got := stackedAreas([]plotter.XYs{user, system})
+	want := []plotter.XYs{
+		{{X: 0, Y: 10}, {X: 1, Y: 20}, {X: 2, Y: 30}, {X: 2, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: 0}},
+		{{X: 0, Y: 15}, {X: 1, Y: 20}, {X: 2, Y: 35}, {X: 2, Y: 30}, {X: 1, Y: 20}, {X: 0, Y: 10}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got areas %v but should be %v", got, want)
+	}
+
+	// a polygon needs at least two points on X
+	for _, layers := range [][]plotter.XYs{nil, {{{X: 0, Y: 10}}}} {
+		if got := stackedAreas(layers); len(got) != 0 {
+			t.Errorf("expected no areas for %v, got %v", layers, got)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	diskColors = []color.Color{
+		color.RGBA{43, 89, 195, 255},
+		color.RGBA{211, 101, 130, 255},
+		color.RGBA{187, 219, 155, 255},
+		color.RGBA{232, 141, 103, 255},
+		color.RGBA{113, 124, 137, 255},
+		color.RGBA{144, 186, 173, 255},
+	}
+
+	diskUsageCollector = collectorFunc{
+		name:     "diskUsage",
+		interval: func() time.Duration { return settings().Intervals.Disk },
+		collect: func(ctx context.Context) ([]Sample, error) {
+			fs, err := Filesystems()
+			if err != nil {
+				return nil, err
+			}
+
+			samples := make([]Sample, 0, 2*len(fs))
+			for mp, f := range fs {
+				ms := DiskUsagePlot.Mount(mp)
+				samples = append(samples,
+					Sample{ms.Usage, f.UsagePercent},
+					Sample{ms.InodeUsage, f.InodeUsagePercent},
+				)
+			}
+			return samples, nil
+		},
+	}
+)
+
+// mountLabel turns a mountpoint into something that can be used in a
+// series name, / becomes root and /data/backup data_backup. Every other
+// byte that isn't a letter, digit, '.', ':' or '-' is escaped as \xNN,
+// including '_', so no two mountpoints get the same label. See
+// mountPointOf for the reverse.
+func mountLabel(mountPoint string) string {
+	if mountPoint == "/" {
+		return "root"
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(strings.Trim(mountPoint, "/")) {
+		switch {
+		case c == '/':
+			b.WriteByte('_')
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == ':', c == '-':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+
+	l := b.String()
+	if l == "root" {
+		// /root would look like /
+		l = `\x72oot`
+	}
+	return l
+}
+
+// mountPointOf returns the mountpoint mountLabel made l from.
+func mountPointOf(l string) (string, error) {
+	if l == "root" {
+		return "/", nil
+	}
+
+	var b strings.Builder
+	b.WriteByte('/')
+	for i := 0; i < len(l); i++ {
+		switch l[i] {
+		case '_':
+			b.WriteByte('/')
+		case '\\':
+			if i+4 > len(l) || l[i+1] != 'x' {
+				return "", fmt.Errorf("invalid escape in mount label %q", l)
+			}
+			c, err := strconv.ParseUint(l[i+2:i+4], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape in mount label %q", l)
+			}
+			b.WriteByte(byte(c))
+			i += 3
+		default:
+			b.WriteByte(l[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// MountSeries holds the usage of a single filesystem in percent.
+type MountSeries struct {
+	Usage      *series.Series
+	InodeUsage *series.Series
+}
+
+// DiskPlot draws the usage of every filesystem that has been discovered so
+// far.
+type DiskPlot struct {
+	mu     sync.RWMutex
+	Mounts map[string]*MountSeries
+}
+
+// Mount returns the series of mountPoint, they are created the first time
+// a filesystem is seen.
+func (dp *DiskPlot) Mount(mountPoint string) *MountSeries {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	ms, ok := dp.Mounts[mountPoint]
+	if !ok {
+		l := mountLabel(mountPoint)
+		ms = &MountSeries{
+			Usage:      newSeries("diskUsage." + l),
+			InodeUsage: newSeries("diskInodes." + l),
+		}
+		dp.Mounts[mountPoint] = ms
+	}
+	return ms
+}
+
+func (dp *DiskPlot) mountPoints() []string {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+
+	mps := make([]string, 0, len(dp.Mounts))
+	for mp := range dp.Mounts {
+		mps = append(mps, mp)
+	}
+	sort.Strings(mps)
+	return mps
+}
+
+func (dp *DiskPlot) Series() []*series.Series {
+	all := make([]*series.Series, 0)
+	for _, mp := range dp.mountPoints() {
+		ms := dp.Mount(mp)
+		all = append(all, ms.Usage, ms.InodeUsage)
+	}
+	return all
+}
+
+func (dp *DiskPlot) png(n int, title string, pick func(*MountSeries) *series.Series) ([]byte, error) {
+	p := setupPlot(title, n)
+	p.Y.Min = 0
+	p.Y.Max = 100
+
+	for i, mp := range dp.mountPoints() {
+		line, err := plotter.NewLine(window(pick(dp.Mount(mp)), n))
+		if err != nil {
+			return []byte{}, err
+		}
+		line.LineStyle = draw.LineStyle{
+			Color: diskColors[i%len(diskColors)],
+			Width: 1.2,
+		}
+
+		p.Add(line)
+		p.Legend.Add(mp, line)
+	}
+
+	return plotToPng(p)
+}
+
+func (dp *DiskPlot) PNG(n int) ([]byte, error) {
+	return dp.png(n, "Disk Usage", func(ms *MountSeries) *series.Series {
+		return ms.Usage
+	})
+}
+
+// DiskInodesPlot draws the inode usage of the filesystems of a DiskPlot.
+// The series are owned by the DiskPlot.
+type DiskInodesPlot struct {
+	*DiskPlot
+}
+
+func (ip DiskInodesPlot) Series() []*series.Series {
+	return []*series.Series{}
+}
+
+func (ip DiskInodesPlot) PNG(n int) ([]byte, error) {
+	return ip.png(n, "Inode Usage", func(ms *MountSeries) *series.Series {
+		return ms.InodeUsage
+	})
+}
@@ -0,0 +1,47 @@
+package stats
+
+import "testing"
+
+func TestMountLabel(t *testing.T) {
+	tests := []struct {
+		mountPoint string
+		want       string
+	}{
+		{"/", "root"},
+		{"/data", "data"},
+		{"/data/x", "data_x"},
+		{"/data_x", `data\x5fx`},
+		{"/mnt/usb disk", `mnt_usb\x20disk`},
+		{"/root", `\x72oot`},
+		{"/srv/nas-1.home:2", "srv_nas-1.home:2"},
+	}
+
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		l := mountLabel(tt.mountPoint)
+		if l != tt.want {
+			t.Errorf("mountLabel(%q) = %q, want %q", tt.mountPoint, l, tt.want)
+		}
+		if other, ok := seen[l]; ok {
+			t.Errorf("%q and %q both get the label %q", other, tt.mountPoint, l)
+		}
+		seen[l] = tt.mountPoint
+
+		mp, err := mountPointOf(l)
+		if err != nil {
+			t.Errorf("mountPointOf(%q): %s", l, err)
+			continue
+		}
+		if mp != tt.mountPoint {
+			t.Errorf("mountPointOf(%q) = %q, want %q", l, mp, tt.mountPoint)
+		}
+	}
+}
+
+func TestMountPointOfInvalid(t *testing.T) {
+	for _, l := range []string{`data\x5`, `data\y5f`, `data\xzz`} {
+		if _, err := mountPointOf(l); err == nil {
+			t.Errorf("mountPointOf(%q) should fail", l)
+		}
+	}
+}
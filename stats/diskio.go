@@ -0,0 +1,329 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/prometheus/procfs/blockdevice"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	blockFS blockdevice.FS
+	diskIO  = new(diskIOCollector)
+)
+
+// diskstats counts sectors in units of 512 bytes, regardless of the
+// sector size of the device.
+const diskstatsSectorSize = 512
+
+// BlockDevices returns the diskstats of every whole block device and md
+// array, partitions and devices denied by the config are left
+// out.
+func BlockDevices() (map[string]blockdevice.Diskstats, error) {
+	filtered := make(map[string]blockdevice.Diskstats)
+
+	devs, err := blockFS.SysBlockDevices()
+	if err != nil {
+		return filtered, err
+	}
+	whole := make(map[string]bool)
+	for _, d := range devs {
+		whole[d] = true
+	}
+
+	dss, err := blockFS.ProcDiskstats()
+	if err != nil {
+		return filtered, err
+	}
+	for _, ds := range dss {
+		if !whole[ds.DeviceName] || matchesAny(ds.DeviceName, settings().BlockDevices.Deny) {
+			continue
+		}
+		filtered[ds.DeviceName] = ds
+	}
+
+	return filtered, nil
+}
+
+// BlockDeviceSeries holds the I/O stats of a single block device.
+type BlockDeviceSeries struct {
+	// ReadBytes and WriteBytes are in MiB/s
+	ReadBytes, WriteBytes *series.Series
+	ReadIOPS, WriteIOPS   *series.Series
+	// Latency is the average time in ms a request took to complete
+	Latency *series.Series
+	// Utilisation is the percentage of time the device was busy
+	Utilisation *series.Series
+}
+
+func newBlockDeviceSeries(name string) *BlockDeviceSeries {
+	prefix := fmt.Sprintf("diskIO.%s.", name)
+	return &BlockDeviceSeries{
+		ReadBytes:   newSeries(prefix + "readBytes"),
+		WriteBytes:  newSeries(prefix + "writeBytes"),
+		ReadIOPS:    newSeries(prefix + "readIOPS"),
+		WriteIOPS:   newSeries(prefix + "writeIOPS"),
+		Latency:     newSeries(prefix + "latency"),
+		Utilisation: newSeries(prefix + "utilisation"),
+	}
+}
+
+func (bs *BlockDeviceSeries) all() []*series.Series {
+	return []*series.Series{
+		bs.ReadBytes, bs.WriteBytes,
+		bs.ReadIOPS, bs.WriteIOPS,
+		bs.Latency, bs.Utilisation,
+	}
+}
+
+// BlockDevicePlot draws the read and write throughput of every block device
+// that has been discovered so far.
+type BlockDevicePlot struct {
+	mu      sync.RWMutex
+	Devices map[string]*BlockDeviceSeries
+}
+
+// Device returns the series of the block device name, they are created the
+// first time a device is seen.
+func (dp *BlockDevicePlot) Device(name string) *BlockDeviceSeries {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	bs, ok := dp.Devices[name]
+	if !ok {
+		bs = newBlockDeviceSeries(name)
+		dp.Devices[name] = bs
+	}
+	return bs
+}
+
+func (dp *BlockDevicePlot) names() []string {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+
+	names := make([]string, 0, len(dp.Devices))
+	for n := range dp.Devices {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (dp *BlockDevicePlot) Series() []*series.Series {
+	all := make([]*series.Series, 0)
+	for _, n := range dp.names() {
+		all = append(all, dp.Device(n).all()...)
+	}
+	return all
+}
+
+func (dp *BlockDevicePlot) PNG(n int) ([]byte, error) {
+	p := setupPlot("Disk I/O (MiB/s)", n)
+	p.Y.Min = 0
+
+	for i, name := range dp.names() {
+		bs := dp.Device(name)
+		c := diskColors[i%len(diskColors)]
+
+		readLine, err := plotter.NewLine(window(bs.ReadBytes, n))
+		if err != nil {
+			return []byte{}, err
+		}
+		readLine.LineStyle = draw.LineStyle{
+			Color: c,
+			Width: 1.2,
+		}
+
+		writeLine, err := plotter.NewLine(window(bs.WriteBytes, n))
+		if err != nil {
+			return []byte{}, err
+		}
+		writeLine.LineStyle = draw.LineStyle{
+			Color:  c,
+			Width:  1.2,
+			Dashes: []vg.Length{vg.Points(4), vg.Points(2)},
+		}
+
+		p.Add(readLine, writeLine)
+		p.Legend.Add(name+" read", readLine)
+		p.Legend.Add(name+" write", writeLine)
+	}
+
+	return plotToPng(p)
+}
+
+// BlockDeviceUtilisationPlot draws how busy the block devices of a
+// BlockDevicePlot were. The series are owned by the BlockDevicePlot.
+type BlockDeviceUtilisationPlot struct {
+	*BlockDevicePlot
+}
+
+func (up BlockDeviceUtilisationPlot) Series() []*series.Series {
+	return []*series.Series{}
+}
+
+func (up BlockDeviceUtilisationPlot) PNG(n int) ([]byte, error) {
+	p := setupPlot("Disk Utilisation", n)
+	p.Y.Min = 0
+	p.Y.Max = 100
+
+	for i, name := range up.names() {
+		line, err := plotter.NewLine(window(up.Device(name).Utilisation, n))
+		if err != nil {
+			return []byte{}, err
+		}
+		line.LineStyle = draw.LineStyle{
+			Color: diskColors[i%len(diskColors)],
+			Width: 1.2,
+		}
+
+		p.Add(line)
+		p.Legend.Add(name, line)
+	}
+
+	return plotToPng(p)
+}
+
+// DiskIOStats are the I/O rates of a block device between two readings of
+// /proc/diskstats.
+type DiskIOStats struct {
+	Device           string  `json:"device"`
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	ReadIOPS         float64 `json:"readIOPS"`
+	WriteIOPS        float64 `json:"writeIOPS"`
+	LatencyMs        float64 `json:"latencyMs"`
+	UtilisationPct   float64 `json:"utilisationPercent"`
+}
+
+// diskIOStats computes the rates between prev and cur. It returns false if
+// any of the counters went backwards, because the device was replaced or a
+// counter wrapped.
+func diskIOStats(name string, prev, cur blockdevice.Diskstats, secs float64) (DiskIOStats, bool) {
+	counters := [][2]uint64{
+		{prev.ReadIOs, cur.ReadIOs},
+		{prev.WriteIOs, cur.WriteIOs},
+		{prev.ReadSectors, cur.ReadSectors},
+		{prev.WriteSectors, cur.WriteSectors},
+		{prev.ReadTicks, cur.ReadTicks},
+		{prev.WriteTicks, cur.WriteTicks},
+		{prev.IOsTotalTicks, cur.IOsTotalTicks},
+	}
+	for _, c := range counters {
+		if c[1] < c[0] {
+			return DiskIOStats{}, false
+		}
+	}
+
+	ios := float64(cur.ReadIOs + cur.WriteIOs - prev.ReadIOs - prev.WriteIOs)
+	ticks := float64(cur.ReadTicks + cur.WriteTicks - prev.ReadTicks - prev.WriteTicks)
+
+	st := DiskIOStats{
+		Device:           name,
+		ReadBytesPerSec:  float64(cur.ReadSectors-prev.ReadSectors) * diskstatsSectorSize / secs,
+		WriteBytesPerSec: float64(cur.WriteSectors-prev.WriteSectors) * diskstatsSectorSize / secs,
+		ReadIOPS:         float64(cur.ReadIOs-prev.ReadIOs) / secs,
+		WriteIOPS:        float64(cur.WriteIOs-prev.WriteIOs) / secs,
+		UtilisationPct:   float64(cur.IOsTotalTicks-prev.IOsTotalTicks) * 100 / (secs * 1000),
+	}
+	if ios > 0 {
+		st.LatencyMs = ticks / ios
+	}
+	if st.UtilisationPct > 100 {
+		st.UtilisationPct = 100
+	}
+
+	return st, true
+}
+
+// diskIOCollector computes per device rates from /proc/diskstats and the
+// time that passed between two readings.
+type diskIOCollector struct {
+	mu       sync.RWMutex
+	prev     map[string]blockdevice.Diskstats
+	prevTime time.Time
+	latest   []DiskIOStats
+}
+
+func (dc *diskIOCollector) Name() string {
+	return "diskIO"
+}
+
+func (dc *diskIOCollector) Interval() time.Duration {
+	return plotInterval()
+}
+
+// LatestDiskIO returns the I/O rates of every block device computed by the
+// most recent run of the collector.
+func LatestDiskIO() []DiskIOStats {
+	return diskIO.latestStats()
+}
+
+func (dc *diskIOCollector) latestStats() []DiskIOStats {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return append([]DiskIOStats{}, dc.latest...)
+}
+
+func (dc *diskIOCollector) Collect(ctx context.Context) ([]Sample, error) {
+	devs, err := BlockDevices()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	prev, prevTime := dc.prev, dc.prevTime
+	dc.prev, dc.prevTime = devs, now
+	if prev == nil {
+		return []Sample{}, nil
+	}
+
+	secs := now.Sub(prevTime).Seconds()
+	if secs <= 0 {
+		return []Sample{}, nil
+	}
+
+	names := make([]string, 0, len(devs))
+	for n := range devs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	samples := make([]Sample, 0)
+	latest := make([]DiskIOStats, 0, len(names))
+	for _, name := range names {
+		p, ok := prev[name]
+		if !ok {
+			continue
+		}
+		st, ok := diskIOStats(name, p, devs[name], secs)
+		if !ok {
+			continue
+		}
+		latest = append(latest, st)
+
+		bs := DiskIOPlot.Device(name)
+		samples = append(samples,
+			Sample{bs.ReadBytes, st.ReadBytesPerSec / (1024 * 1024)},
+			Sample{bs.WriteBytes, st.WriteBytesPerSec / (1024 * 1024)},
+			Sample{bs.ReadIOPS, st.ReadIOPS},
+			Sample{bs.WriteIOPS, st.WriteIOPS},
+			Sample{bs.Latency, st.LatencyMs},
+			Sample{bs.Utilisation, st.UtilisationPct},
+		)
+	}
+	dc.latest = latest
+
+	return samples, nil
+}
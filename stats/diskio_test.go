@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/prometheus/procfs/blockdevice"
+)
+
+func TestDiskIOStats(t *testing.T) {
+	stats := func(readIOs, writeIOs, readSectors, writeSectors, readTicks, writeTicks, totalTicks uint64) blockdevice.Diskstats {
+		return blockdevice.Diskstats{IOStats: blockdevice.IOStats{
+			ReadIOs:       readIOs,
+			WriteIOs:      writeIOs,
+			ReadSectors:   readSectors,
+			WriteSectors:  writeSectors,
+			ReadTicks:     readTicks,
+			WriteTicks:    writeTicks,
+			IOsTotalTicks: totalTicks,
+		}}
+	}
+	prev := stats(100, 200, 1000, 2000, 300, 600, 5000)
+
+	tests := []struct {
+		name string
+		cur  blockdevice.Diskstats
+		ok   bool
+		want DiskIOStats
+	}{
+		{
+			name: "rates",
+			cur:  stats(120, 220, 1400, 2800, 340, 680, 5500),
+			ok:   true,
+			want: DiskIOStats{
+				Device:           "sda",
+				ReadBytesPerSec:  400 * diskstatsSectorSize / 2,
+				WriteBytesPerSec: 800 * diskstatsSectorSize / 2,
+				ReadIOPS:         10,
+				WriteIOPS:        10,
+				LatencyMs:        3,
+				UtilisationPct:   25,
+			},
+		},
+		{
+			name: "idle",
+			cur:  prev,
+			ok:   true,
+			want: DiskIOStats{Device: "sda"},
+		},
+		{
+			name: "utilisation is capped",
+			cur:  stats(100, 200, 1000, 2000, 300, 600, 9000),
+			ok:   true,
+			want: DiskIOStats{Device: "sda", UtilisationPct: 100},
+		},
+		{name: "read IOs reset", cur: stats(0, 220, 1400, 2800, 340, 680, 5500)},
+		{name: "write IOs reset", cur: stats(120, 0, 1400, 2800, 340, 680, 5500)},
+		{name: "read sectors wrapped", cur: stats(120, 220, 10, 2800, 340, 680, 5500)},
+		{name: "write sectors wrapped", cur: stats(120, 220, 1400, 10, 340, 680, 5500)},
+		{name: "read ticks wrapped", cur: stats(120, 220, 1400, 2800, 10, 680, 5500)},
+		{name: "write ticks wrapped", cur: stats(120, 220, 1400, 2800, 340, 10, 5500)},
+		{name: "total ticks reset", cur: stats(120, 220, 1400, 2800, 340, 680, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := diskIOStats("sda", prev, tt.cur, 2)
+			if ok != tt.ok {
+				t.Fatalf("expected ok to be %t, got %t with %+v", tt.ok, ok, got)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v but should be %+v", got, tt.want)
+			}
+		})
+	}
+}
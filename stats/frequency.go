@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/series"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	CPUFrequencyPlot = &FrequencyPlot{
+		Arm:  series.NewSeries("cpuFreq.arm", config.PlotDatapoints),
+		Core: series.NewSeries("cpuFreq.core", config.PlotDatapoints),
+	}
+	CoreVoltagePlot SingleValuePlot = SingleValuePlot{
+		Value: series.NewSeries("coreVoltage", config.PlotDatapoints),
+		Name:  "Core Voltage",
+		LineStyle: &draw.LineStyle{
+			Color: color.RGBA{211, 101, 130, 255},
+			Width: 1.2,
+		},
+	}
+)
+
+// FrequencyPlot draws the ARM and core clock in MHz.
+type FrequencyPlot struct {
+	Arm, Core *series.Series
+}
+
+func (fp *FrequencyPlot) Series() []*series.Series {
+	return []*series.Series{fp.Arm, fp.Core}
+}
+
+func (fp *FrequencyPlot) PNG(n int) ([]byte, error) {
+	armLine, err := plotter.NewLine(window(fp.Arm, n))
+	if err != nil {
+		return []byte{}, err
+	}
+	armLine.LineStyle = draw.LineStyle{
+		Color: color.RGBA{216, 87, 42, 255},
+		Width: 1.2,
+	}
+
+	coreLine, err := plotter.NewLine(window(fp.Core, n))
+	if err != nil {
+		return []byte{}, err
+	}
+	coreLine.LineStyle = draw.LineStyle{
+		Color:  color.RGBA{43, 89, 195, 255},
+		Width:  1.2,
+		Dashes: []vg.Length{vg.Points(4), vg.Points(2)},
+	}
+
+	p := setupPlot("CPU Frequency (MHz)", n)
+	p.Y.Min = 0
+	p.Add(armLine, coreLine)
+	p.Legend.Add("ARM", armLine)
+	p.Legend.Add("Core", coreLine)
+
+	return plotToPng(p)
+}
+
+// hasVcgencmd reports whether vcgencmd is installed, which it only is on
+// Raspberry Pi OS.
+func hasVcgencmd() bool {
+	_, err := exec.LookPath("vcgencmd")
+	return err == nil
+}
+
+// hasFrequencySource reports whether the clock can be read from either
+// cpufreq or vcgencmd.
+func hasFrequencySource() bool {
+	if hasVcgencmd() {
+		return true
+	}
+	m, _ := filepath.Glob(filepath.Join(settings().Paths.Sysfs, "devices", "system", "cpu", "cpu[0-9]*", "cpufreq", "scaling_cur_freq"))
+	return len(m) > 0
+}
+
+var (
+	// frequencyCollector only fails if the ARM clock can't be read, core
+	// clock and voltage are only available through vcgencmd.
+	frequencyCollector = collectorFunc{
+		name:     "cpuFreq",
+		interval: plotInterval,
+		collect: func(ctx context.Context) ([]Sample, error) {
+			arm, err := CPUFrequency(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := []Sample{{CPUFrequencyPlot.Arm, arm / 1e6}}
+
+			if core, err := ClockFrequency(ctx, "core"); err == nil {
+				samples = append(samples, Sample{CPUFrequencyPlot.Core, core / 1e6})
+			} else if !errors.Is(err, exec.ErrNotFound) {
+				return samples, err
+			}
+
+			if volts, err := CoreVoltage(ctx); err == nil {
+				samples = append(samples, Sample{CoreVoltagePlot.Value, volts})
+			} else if !errors.Is(err, exec.ErrNotFound) {
+				return samples, err
+			}
+
+			return samples, nil
+		},
+	}
+)
@@ -1,59 +1,31 @@
 package stats
 
 import (
-	"fmt"
-	"os/exec"
-	"regexp"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/pbaettig/raspi-dash/sensors"
 	"github.com/prometheus/procfs"
 	"golang.org/x/sys/unix"
 )
 
-func runVcgencmd(cmd string) (vcgencmdOutput, error) {
-	c := exec.Command("vcgencmd", cmd)
-	out, err := c.Output()
-	if err != nil {
-		return vcgencmdOutput{}, err
-	}
-
-	split := strings.SplitN(string(out), "=", 2)
-	if len(split) != 2 {
-		return vcgencmdOutput{}, fmt.Errorf("cannot parse vcgencmd output: %s", string(out))
-	}
-	return vcgencmdOutput{split[0], split[1]}, nil
+// CPUTemperature returns the CPU temperature from sysfs or vcgencmd,
+// whatever is available.
+func CPUTemperature(ctx context.Context) (float64, error) {
+	return Sensors.CPUTemperature(ctx)
 }
 
-func CPUTemperature() (float64, error) {
-	t, err := runVcgencmd("measure_temp")
-	if err != nil {
-		return 0, err
-	}
-
-	p := regexp.MustCompile(`^([\d\.]+)`)
-	sm := p.FindStringSubmatch(t.Value)
-	if len(sm) == 0 {
-		return 0, fmt.Errorf("cannot parse temperature")
-	}
-
-	temp, err := strconv.ParseFloat(sm[0], 64)
-	if err != nil {
-		return 0, fmt.Errorf("cannot convert temperature: %w", err)
-	}
-
-	return temp, nil
-}
-
-func CPUThrottlingStatus() (throttleStatus, error) {
-	r, err := runVcgencmd("get_throttled")
+func CPUThrottlingStatus(ctx context.Context) (throttleStatus, error) {
+	_, v, err := sensors.RunVcgencmd(ctx, "get_throttled")
 	if err != nil {
 		return throttleStatus{}, err
 	}
 
-	// r := vcgencmdOutput{"", "0x20002"}
-
-	t, err := strconv.ParseInt(r.Value, 0, 64)
+	t, err := strconv.ParseInt(v, 0, 64)
 	if err != nil {
 		return throttleStatus{}, err
 	}
@@ -72,6 +44,58 @@ func CPUThrottlingStatus() (throttleStatus, error) {
 	return ts, nil
 }
 
+// CPUFrequency returns the current ARM clock in Hz. It's the highest
+// frequency of all cpufreq policies in sysfs, or the one reported by
+// vcgencmd if there is no cpufreq.
+func CPUFrequency(ctx context.Context) (float64, error) {
+	paths, err := filepath.Glob(filepath.Join(settings().Paths.Sysfs, "devices", "system", "cpu", "cpu[0-9]*", "cpufreq", "scaling_cur_freq"))
+	if err != nil {
+		return 0, err
+	}
+
+	max := float64(-1)
+	for _, p := range paths {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		khz, err := strconv.ParseFloat(strings.TrimSpace(string(buf)), 64)
+		if err != nil {
+			continue
+		}
+		if khz*1000 > max {
+			max = khz * 1000
+		}
+	}
+	if max >= 0 {
+		return max, nil
+	}
+
+	return ClockFrequency(ctx, "arm")
+}
+
+// ClockFrequency returns the frequency of clock in Hz as reported by
+// `vcgencmd measure_clock`, e.g. arm or core.
+func ClockFrequency(ctx context.Context, clock string) (float64, error) {
+	_, v, err := sensors.RunVcgencmd(ctx, "measure_clock", clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(v, 64)
+}
+
+// CoreVoltage returns the voltage of the SoC core as reported by
+// `vcgencmd measure_volts core`.
+func CoreVoltage(ctx context.Context) (float64, error) {
+	_, v, err := sensors.RunVcgencmd(ctx, "measure_volts", "core")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSuffix(v, "V"), 64)
+}
+
 func MDStats() ([]procfs.MDStat, error) {
 	return proc.MDStat()
 }
@@ -85,9 +109,38 @@ func LoadAvg() (*procfs.LoadAvg, error) {
 	return proc.LoadAvg()
 }
 
-func NetworkEth0() (procfs.NetDevLine, error) {
-	ndl, err := proc.NetDev()
-	return ndl["eth0"], err
+// matchesAny reports whether name matches one of the shell patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if m, _ := path.Match(p, name); m {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkInterfaces returns the counters of every interface in /proc/net/dev
+// that is allowed and not denied by the network section of the config.
+func NetworkInterfaces() (procfs.NetDev, error) {
+	filtered := make(procfs.NetDev)
+
+	ndev, err := proc.NetDev()
+	if err != nil {
+		return filtered, err
+	}
+
+	nc := settings().Network
+	for name, l := range ndev {
+		if len(nc.Allow) > 0 && !matchesAny(name, nc.Allow) {
+			continue
+		}
+		if matchesAny(name, nc.Deny) {
+			continue
+		}
+		filtered[name] = l
+	}
+
+	return filtered, nil
 }
 
 func Mounts() ([]*procfs.MountInfo, error) {
@@ -98,27 +151,29 @@ func Mounts() ([]*procfs.MountInfo, error) {
 		return filtered, err
 	}
 
+	types := settings().Filesystems.Types
+	seen := make(map[string]bool)
 	for _, mi := range mis {
-		if strings.HasPrefix(mi.FSType, "ext") {
-			filtered = append(filtered, mi)
-			continue
-		}
-
-		if strings.Contains(mi.FSType, "fat") {
-			filtered = append(filtered, mi)
+		if seen[mi.MountPoint] || !matchesAny(mi.FSType, types) {
 			continue
 		}
+		seen[mi.MountPoint] = true
+		filtered = append(filtered, mi)
 	}
 
 	return filtered, nil
 }
 
 type filesystem struct {
-	Type         string
-	SizeBytes    uint64
-	FreeBytes    uint64
-	UsedBytes    uint64
-	UsagePercent float64
+	Type              string
+	SizeBytes         uint64
+	FreeBytes         uint64
+	UsedBytes         uint64
+	UsagePercent      float64
+	Inodes            uint64
+	InodesFree        uint64
+	InodesUsed        uint64
+	InodeUsagePercent float64
 }
 
 func Filesystems() (map[string]filesystem, error) {
@@ -136,22 +191,28 @@ func Filesystems() (map[string]filesystem, error) {
 			continue
 		}
 		size := stat.Blocks * uint64(stat.Bsize)
+		if size == 0 {
+			continue
+		}
 		free := stat.Bavail * uint64(stat.Bsize)
 		used := size - free
-		f := new(filesystem)
-		f.Type = m.FSType
-		f.SizeBytes = size
-		f.FreeBytes = free
-		f.UsedBytes = used
-		f.UsagePercent = float64(used) * 100 / float64(size)
-
-		fs[m.MountPoint] = filesystem{
+
+		f := filesystem{
 			Type:         m.FSType,
 			SizeBytes:    size,
 			FreeBytes:    free,
 			UsedBytes:    used,
 			UsagePercent: float64(used) * 100 / float64(size),
+			Inodes:       stat.Files,
+			InodesFree:   stat.Ffree,
+			InodesUsed:   stat.Files - stat.Ffree,
 		}
+		// some filesystems (e.g. fat, btrfs) don't have a fixed number of inodes
+		if f.Inodes > 0 {
+			f.InodeUsagePercent = float64(f.InodesUsed) * 100 / float64(f.Inodes)
+		}
+
+		fs[m.MountPoint] = f
 	}
 	return fs, nil
 }
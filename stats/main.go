@@ -1,20 +1,34 @@
 package stats
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/pbaettig/raspi-dash/alerts"
 	"github.com/pbaettig/raspi-dash/borg"
 	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/letsencrypt"
+	"github.com/pbaettig/raspi-dash/notify"
+	"github.com/pbaettig/raspi-dash/raid"
+	"github.com/pbaettig/raspi-dash/sensors"
+	"github.com/pbaettig/raspi-dash/series"
 	"github.com/pbaettig/raspi-dash/templates"
 	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
 )
 
-type vcgencmdOutput struct {
-	Key   string
-	Value string
-}
+const (
+	raidHistoryShown = 10
+	backupsShown     = 5
+
+	// alertStaleIntervals is how many collection intervals the latest
+	// datapoint of a series may be old before its alert rules are stale
+	alertStaleIntervals = 3
+)
 
 type throttleStatus struct {
 	UnderVoltage                      bool
@@ -28,93 +42,352 @@ type throttleStatus struct {
 }
 
 var (
-	proc                   procfs.FS
-	DocumentsNewestBackups []borg.Archive
-	PhotosNewestBackups    []borg.Archive
-	Backups                map[string][]borg.Archive = map[string][]borg.Archive{
-		borg.DocumentsRepo.Name: {},
-		borg.PhotosRepo.Name:    {},
-	}
+	proc procfs.FS
+
+	backupsMu sync.Mutex
+	repos     []borg.Repo
+	Backups   map[string][]borg.Archive = map[string][]borg.Archive{}
+
+	Collectors = new(Registry)
+	RAID       *raid.Monitor
+	Sensors    *sensors.Sensors
+	Alerts     *alerts.Engine
+	Notifier   *notify.Dispatcher
+	Certs      *letsencrypt.Manager
 
-	prevNetworkRx float64
-	prevNetworkTx float64
+	store series.Store
+
+	cfgMu    sync.RWMutex
+	cfg      = config.Default()
+	reloaded = make(chan struct{}, 1)
+
+	// background goroutines started by Start, except for the collectors
+	background sync.WaitGroup
 )
 
-func init() {
+// settings returns the config stats was started with.
+func settings() *config.Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+
+	return cfg
+}
+
+// Start sets up all collectors according to c, restores the persisted
+// series and starts collecting until ctx is done. Use Stop to wait for
+// everything to wind down.
+func Start(ctx context.Context, c *config.Config) error {
+	cfgMu.Lock()
+	cfg = c
+	cfgMu.Unlock()
+
 	var err error
 	proc, err = procfs.NewDefaultFS()
 	if err != nil {
-		log.Fatalln(err.Error())
+		return err
+	}
+
+	blockFS, err = blockdevice.NewDefaultFS()
+	if err != nil {
+		return err
 	}
 
-	go updateTicker()
+	RAID, err = raid.NewMonitor(procfs.DefaultMountPoint)
+	if err != nil {
+		log.Printf("cannot monitor RAID arrays: %s", err.Error())
+	}
+
+	Sensors = sensors.New(c.Paths.Sysfs)
+
+	if err := registerCPUPlots(); err != nil {
+		log.Printf("cannot set up per core CPU plots: %s", err.Error())
+	}
+
+	loadSeries(c.Paths.SeriesData)
+
+	setRepos(c.Borg)
+
+	Collectors.Register(cpuTemperatureCollector)
+	Collectors.Register(loadAvgCollector)
+	Collectors.Register(memoryCollector)
+	Collectors.Register(diskUsageCollector)
+	Collectors.Register(new(networkCollector))
+	Collectors.Register(new(cpuCollector))
+	Collectors.Register(diskIO)
+	if RAID != nil {
+		Collectors.Register(raidCollector)
+	}
+	// get_throttled is only available on Raspberry Pi OS
+	if hasVcgencmd() {
+		Collectors.Register(throttleCollector)
+	}
+	if hasFrequencySource() {
+		Collectors.Register(frequencyCollector)
+	}
+
+	Alerts, err = alerts.NewEngine(c.Alerts.Rules, func(name string) *series.Series {
+		return AllSeries()[name]
+	})
+	if err != nil {
+		log.Println(err.Error())
+	} else {
+		Alerts.SetMaxAge(alertMaxAge(c))
+		Collectors.Register(alertCollector)
+	}
+	Notifier = notify.NewDispatcher(notifiers(c.Alerts)...)
+
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		Notifier.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		updateTicker(ctx)
+	}()
+
+	return nil
 }
 
-func updateBackups() {
-	dbs, err := borg.DocumentsRepo.ListBackupArchives()
-	if err == nil {
-		// DocumentsNewestBackups = dbs[:5]
-		Backups[borg.DocumentsRepo.Name] = dbs[:5]
+// Stop waits until everything started by Start has returned after its
+// context is done, then flushes and closes the series store. If ctx
+// expires first the store is left open, collectors may still push to it.
+func Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		Collectors.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// collectors that are still running may push at any time, the
+		// store is left to be closed on exit
+		return fmt.Errorf("stats did not stop in time, series are not flushed: %w", ctx.Err())
 	}
-	pbs, err := borg.PhotosRepo.ListBackupArchives()
-	if err == nil {
-		// PhotosNewestBackups = pbs[:5]
-		Backups[borg.PhotosRepo.Name] = pbs[:5]
+
+	if store != nil {
+		return store.Close()
 	}
+	return nil
 }
 
-func updatePlots(t time.Time) {
-	temp, _ := CPUTemperature()
+// Reload applies c to everything that has been set up by Start: intervals,
+// filters, alert rules, notifiers and borg repos. The collected series are
+// kept. Paths, the plot size and the listeners need a restart. If c can't
+// be applied nothing is changed.
+func Reload(c *config.Config) error {
+	prev := settings()
 
-	CPUTemperaturePlot.Value.Datapoints.Push(t, temp)
+	if Alerts != nil {
+		if err := Alerts.SetRules(c.Alerts.Rules); err != nil {
+			return err
+		}
+		Alerts.SetMaxAge(alertMaxAge(c))
+	}
 
-	avg, _ := LoadAvg()
-	LoadAvgPlot.Avg1.Datapoints.Push(t, avg.Load1)
-	LoadAvgPlot.Avg5.Datapoints.Push(t, avg.Load5)
-	LoadAvgPlot.Avg15.Datapoints.Push(t, avg.Load15)
+	if c.Paths.SeriesData != prev.Paths.SeriesData || c.Paths.Sysfs != prev.Paths.Sysfs {
+		log.Println("paths.seriesData and paths.sysfs are only applied after a restart")
+	}
 
-	mi, _ := Meminfo()
-	MemoryUsedPlot.Value.Datapoints.Push(t, (float64(*mi.MemTotal)-float64(*mi.MemFree))*100/float64(*mi.MemTotal))
+	cfgMu.Lock()
+	cfg = c
+	cfgMu.Unlock()
 
-	fs, err := Filesystems()
-	if err != nil {
-		log.Fatalln(err.Error())
+	Notifier.SetNotifiers(notifiers(c.Alerts)...)
+	setRepos(c.Borg)
+
+	select {
+	case reloaded <- struct{}{}:
+	default:
 	}
 
-	DiskUsagePlot.Boot.Datapoints.Push(t, fs["/boot"].UsagePercent)
-	DiskUsagePlot.Data.Datapoints.Push(t, fs["/data"].UsagePercent)
-	DiskUsagePlot.Root.Datapoints.Push(t, fs["/"].UsagePercent)
+	return nil
+}
 
-	n, _ := NetworkEth0()
+// alertMaxAge returns how old datapoints may be to be evaluated, a few of
+// the longest interval series are collected at.
+func alertMaxAge(c *config.Config) time.Duration {
+	d := c.Plots.UpdateInterval
+	if c.Intervals.Disk > d {
+		d = c.Intervals.Disk
+	}
+	return alertStaleIntervals * d
+}
 
-	rxR := 0.0
-	txR := 0.0
+// setRepos replaces the borg repos whose backups are listed, the backups
+// of repos that are still there are kept.
+func setRepos(rs []config.BorgRepo) {
+	backupsMu.Lock()
+	defer backupsMu.Unlock()
+
+	repos = make([]borg.Repo, 0, len(rs))
+	backups := make(map[string][]borg.Archive)
+	for _, r := range rs {
+		repos = append(repos, borg.Repo{Name: r.Name, ID: r.ID, Passphrase: r.Passphrase})
+		backups[r.Name] = Backups[r.Name]
+		if backups[r.Name] == nil {
+			backups[r.Name] = []borg.Archive{}
+		}
+	}
+	Backups = backups
+}
 
-	if prevNetworkRx != 0 {
-		rxR = (float64(n.RxBytes) - prevNetworkRx) / (1024 * 1024)
+// notifiers returns every alert notifier that is configured.
+func notifiers(c config.Alerts) []notify.Notifier {
+	ns := make([]notify.Notifier, 0)
+	if c.Webhook.URL != "" {
+		ns = append(ns, notify.Webhook{URL: c.Webhook.URL})
 	}
-	if prevNetworkTx != 0 {
-		txR = (float64(n.TxBytes) - prevNetworkTx) / (1024 * 1024)
+	if c.Push.URL != "" {
+		ns = append(ns, notify.Push{URL: c.Push.URL, Token: c.Push.Token})
 	}
+	if c.SMTP.Addr != "" {
+		ns = append(ns, notify.SMTP{
+			Addr:     c.SMTP.Addr,
+			From:     c.SMTP.From,
+			To:       c.SMTP.To,
+			Username: c.SMTP.Username,
+			Password: c.SMTP.Password,
+		})
+	}
+	return ns
+}
 
-	NetworkRxTxPlot.Rx.Datapoints.Push(t, rxR)
-	NetworkRxTxPlot.Tx.Datapoints.Push(t, txR)
-	prevNetworkRx = float64(n.RxBytes)
-	prevNetworkTx = float64(n.TxBytes)
+// newSeries creates a series that is persisted if a store is available.
+func newSeries(name string) *series.Series {
+	s := series.NewSeries(name, config.PlotDatapoints)
+	if store != nil {
+		if err := s.Persist(store); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return s
+}
+
+// loadSeries restores the history of all series from disk, including the
+// interfaces, mounts and block devices that aren't there anymore. Without
+// a working store the dashboard still runs, it just starts with empty
+// plots.
+func loadSeries(dir string) {
+	fs, err := series.NewFileStore(dir)
+	if err != nil {
+		log.Printf("series will not be persisted: %s", err.Error())
+		return
+	}
+	store = fs
+
+	for _, s := range AllSeries() {
+		if err := s.Persist(store); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	names, err := fs.Names()
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	for _, n := range names {
+		// rollups are stored as <series>@<tier>.<aggregate>
+		if i := strings.Index(n, "@"); i >= 0 {
+			n = n[:i]
+		}
+		if err := restoreSeries(n); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// restoreSeries creates the series name belongs to if it is one of those
+// that are only created once their interface, mount or device is seen.
+// Creating them loads their history.
+func restoreSeries(name string) error {
+	// the metric comes last, interface names may contain dots
+	inner := func(prefix string) string {
+		n := strings.TrimPrefix(name, prefix)
+		if i := strings.LastIndex(n, "."); i > 0 {
+			return n[:i]
+		}
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(name, "network."):
+		if iface := inner("network."); iface != "" {
+			NetworkRxTxPlot.Interface(iface)
+		}
+	case strings.HasPrefix(name, "diskIO."):
+		if dev := inner("diskIO."); dev != "" {
+			DiskIOPlot.Device(dev)
+		}
+	case strings.HasPrefix(name, "diskUsage."), strings.HasPrefix(name, "diskInodes."):
+		mp, err := mountPointOf(name[strings.Index(name, ".")+1:])
+		if err != nil {
+			return fmt.Errorf("cannot restore series %s: %w", name, err)
+		}
+		DiskUsagePlot.Mount(mp)
+	}
+	return nil
 }
 
-func updateTicker() {
-	backupTicker := time.NewTicker(config.BackupUpdateInterval)
-	plotTicker := time.NewTicker(config.PlotUpdateInterval)
+func updateBackups(ctx context.Context) {
+	backupsMu.Lock()
+	rs := append([]borg.Repo{}, repos...)
+	backupsMu.Unlock()
+
+	for _, r := range rs {
+		as, err := r.ListBackupArchives(ctx)
+		if err != nil {
+			log.Printf("cannot list backups of %s: %s", r.Name, err.Error())
+			continue
+		}
+		if len(as) > backupsShown {
+			as = as[:backupsShown]
+		}
 
-	go updateBackups()
+		backupsMu.Lock()
+		if _, ok := Backups[r.Name]; ok {
+			Backups[r.Name] = as
+		}
+		backupsMu.Unlock()
+	}
+}
+
+func updateTicker(ctx context.Context) {
+	backupTicker := time.NewTicker(settings().Intervals.Backup)
+	defer backupTicker.Stop()
+	plotTicker := time.NewTicker(settings().Plots.UpdateInterval)
+	defer plotTicker.Stop()
+
+	// borg runs can take longer than the interval, they are waited for
+	// when the ticker stops
+	var backups sync.WaitGroup
+	defer backups.Wait()
+	listBackups := func() {
+		backups.Add(1)
+		go func() {
+			defer backups.Done()
+			updateBackups(ctx)
+		}()
+	}
+
+	listBackups()
 
 	for {
 		select {
 		case t := <-plotTicker.C:
-			go updatePlots(t)
+			Collectors.Tick(ctx, t)
 		case <-backupTicker.C:
-			go updateBackups()
+			listBackups()
+		case <-reloaded:
+			backupTicker.Reset(settings().Intervals.Backup)
+			plotTicker.Reset(settings().Plots.UpdateInterval)
+			listBackups()
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -137,20 +410,48 @@ func PrepareIndexPageData() templates.IndexPageData {
 		ipd.Plots[n] = fmt.Sprintf("/plot/%s?range=-1", n)
 	}
 
-	ipd.RaidStats, _ = MDStats()
+	if RAID != nil {
+		ipd.RaidArrays = RAID.Arrays()
+		ipd.RaidDegraded = RAID.Degraded()
+		ipd.RaidHistory = RAID.History()
+		if len(ipd.RaidHistory) > raidHistoryShown {
+			ipd.RaidHistory = ipd.RaidHistory[:raidHistoryShown]
+		}
+	}
 
 	// update Backup Age
+	backupsMu.Lock()
+	ipd.Backups = make(map[string][]borg.Archive)
 	for k := range Backups {
-		for i := range Backups[k] {
-			c := time.Time(Backups[k][i].Created)
-			Backups[k][i].Age = time.Since(c.In(time.Local))
+		ipd.Backups[k] = make([]borg.Archive, len(Backups[k]))
+		for i, a := range Backups[k] {
+			a.Age = time.Since(time.Time(a.Created).In(time.Local))
+			ipd.Backups[k][i] = a
 		}
 	}
+	backupsMu.Unlock()
+
+	if Alerts != nil {
+		ipd.Alerts = Alerts.Active()
+		ipd.StaleAlerts = Alerts.Stale()
+	}
 
-	ipd.Backups = Backups
+	ipd.ThrottleWarnings = throttleWarnings(LatestThrottleStatus())
+
+	if Certs != nil {
+		cs := Certs.Status()
+		ipd.Certificate = &cs
+	}
+
+	ipd.CollectorErrors = make(map[string]string)
+	for _, cs := range Collectors.Status() {
+		if cs.LastError != "" {
+			ipd.CollectorErrors[cs.Name] = cs.LastError
+		}
+	}
 
 	ipd.RangeSliderMin = 60
-	ipd.RangeSliderMax = config.PlotDatapoints
+	ipd.RangeSliderMax = int(config.PlotMaxRange / settings().Plots.UpdateInterval)
 
 	return ipd
 }
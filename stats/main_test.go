@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/series"
+	"gonum.org/v1/plot/plotter"
+)
+
+// keepGlobals restores everything Start and loadSeries change once t is
+// done. The collectors are registered with a new Registry, and interfaces,
+// mounts and devices start out empty.
+func keepGlobals(t *testing.T) {
+	prevCfg, prevProc, prevBlockFS := cfg, proc, blockFS
+	prevRAID, prevSensors, prevCollectors := RAID, Sensors, Collectors
+	prevAlerts, prevNotifier, prevCerts := Alerts, Notifier, Certs
+	prevStore, prevRepos, prevBackups := store, repos, Backups
+	prevPlots, prevCorePlots := AllPlots, CPUCorePlots
+	prevIfaces, prevMounts, prevDevs := NetworkRxTxPlot.Interfaces, DiskUsagePlot.Mounts, DiskIOPlot.Devices
+
+	t.Cleanup(func() {
+		if store != prevStore {
+			// none of the series were persisted before
+			for _, s := range AllSeries() {
+				s.Persist(nil)
+			}
+			store.Close()
+		}
+
+		cfg, proc, blockFS = prevCfg, prevProc, prevBlockFS
+		RAID, Sensors, Collectors = prevRAID, prevSensors, prevCollectors
+		Alerts, Notifier, Certs = prevAlerts, prevNotifier, prevCerts
+		store, repos, Backups = prevStore, prevRepos, prevBackups
+		AllPlots, CPUCorePlots = prevPlots, prevCorePlots
+		NetworkRxTxPlot.Interfaces, DiskUsagePlot.Mounts, DiskIOPlot.Devices = prevIfaces, prevMounts, prevDevs
+	})
+
+	Collectors = new(Registry)
+	AllPlots = make(map[string]StatPlotter, len(prevPlots))
+	for n, p := range prevPlots {
+		AllPlots[n] = p
+	}
+	NetworkRxTxPlot.Interfaces = make(map[string]*InterfaceSeries)
+	DiskUsagePlot.Mounts = make(map[string]*MountSeries)
+	DiskIOPlot.Devices = make(map[string]*BlockDeviceSeries)
+}
+
+func TestStartStop(t *testing.T) {
+	keepGlobals(t)
+	before := runtime.NumGoroutine()
+
+	c := config.Default()
+	c.Paths.SeriesData = t.TempDir()
+	c.Plots.UpdateInterval = 10 * time.Millisecond
+	c.Alerts.Rules = map[string]string{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := Start(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	// let a few ticks run the collectors
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer scancel()
+	if err := Stop(sctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// goroutines that returned may not be gone immediately
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<16)
+		t.Fatalf("%d goroutines leaked:\n%s", n-before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestLoadSeries(t *testing.T) {
+	keepGlobals(t)
+
+	dir := t.TempDir()
+	fs, err := series.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xy := plotter.XY{X: 1631185200, Y: 42}
+	for _, n := range []string{
+		"network.eth0.100.rx",
+		`diskUsage.data\x5fx@1m.avg`,
+		"diskIO.sda.readBytes",
+		"cpuTemp",
+	} {
+		if err := fs.Append(n, xy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	loadSeries(dir)
+
+	is, ok := NetworkRxTxPlot.Interfaces["eth0.100"]
+	if !ok {
+		t.Fatalf("interface eth0.100 not restored, got %v", NetworkRxTxPlot.names())
+	}
+	if got := is.Rx.Datapoints.Latest(); got != xy {
+		t.Errorf("expected %v for network.eth0.100.rx, got %v", xy, got)
+	}
+
+	ms, ok := DiskUsagePlot.Mounts["/data_x"]
+	if !ok {
+		t.Fatalf("mount /data_x not restored, got %v", DiskUsagePlot.mountPoints())
+	}
+	if got := ms.Usage.Rollups[0].Avg.Latest(); got != xy {
+		t.Errorf("expected %v for the rollup of %s, got %v", xy, ms.Usage.Name, got)
+	}
+
+	if _, ok := DiskIOPlot.Devices["sda"]; !ok {
+		t.Errorf("device sda not restored, got %v", DiskIOPlot.names())
+	}
+}
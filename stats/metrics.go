@@ -0,0 +1,282 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/pbaettig/raspi-dash/metrics"
+	"github.com/prometheus/procfs"
+)
+
+const metricsPrefix = "raspi_"
+
+func loadMetrics() []*metrics.Family {
+	load1 := metrics.NewGauge(metricsPrefix+"load1", "1m load average.")
+	load5 := metrics.NewGauge(metricsPrefix+"load5", "5m load average.")
+	load15 := metrics.NewGauge(metricsPrefix+"load15", "15m load average.")
+
+	avg, err := LoadAvg()
+	if err != nil {
+		log.Printf("metrics: cannot read load average: %s", err.Error())
+		return nil
+	}
+	load1.Add(avg.Load1)
+	load5.Add(avg.Load5)
+	load15.Add(avg.Load15)
+
+	return []*metrics.Family{load1, load5, load15}
+}
+
+func cpuMetrics() []*metrics.Family {
+	st, err := proc.Stat()
+	if err != nil {
+		log.Printf("metrics: cannot read stat: %s", err.Error())
+		return nil
+	}
+
+	secs := metrics.NewCounter(metricsPrefix+"cpu_seconds_total", "Seconds the CPUs spent in each mode.")
+	add := func(cpu string, s procfs.CPUStat) {
+		secs.Add(s.User, "cpu", cpu, "mode", "user")
+		secs.Add(s.Nice, "cpu", cpu, "mode", "nice")
+		secs.Add(s.System, "cpu", cpu, "mode", "system")
+		secs.Add(s.Idle, "cpu", cpu, "mode", "idle")
+		secs.Add(s.Iowait, "cpu", cpu, "mode", "iowait")
+		secs.Add(s.IRQ, "cpu", cpu, "mode", "irq")
+		secs.Add(s.SoftIRQ, "cpu", cpu, "mode", "softirq")
+		secs.Add(s.Steal, "cpu", cpu, "mode", "steal")
+	}
+	for i, c := range st.CPU {
+		add(strconv.Itoa(i), c)
+	}
+
+	return []*metrics.Family{secs}
+}
+
+func memoryMetrics() []*metrics.Family {
+	mi, err := Meminfo()
+	if err != nil {
+		log.Printf("metrics: cannot read meminfo: %s", err.Error())
+		return nil
+	}
+
+	fams := make([]*metrics.Family, 0)
+	add := func(name, help string, kb *uint64) {
+		if kb == nil {
+			return
+		}
+		f := metrics.NewGauge(metricsPrefix+"memory_"+name+"_bytes", help)
+		f.Add(float64(*kb) * 1024)
+		fams = append(fams, f)
+	}
+	add("total", "Total usable memory.", mi.MemTotal)
+	add("free", "Memory not used at all.", mi.MemFree)
+	add("available", "Memory available for starting new applications.", mi.MemAvailable)
+	add("buffers", "Memory used by block device buffers.", mi.Buffers)
+	add("cached", "Memory used by the page cache.", mi.Cached)
+	add("swap_total", "Total swap space.", mi.SwapTotal)
+	add("swap_free", "Unused swap space.", mi.SwapFree)
+
+	return fams
+}
+
+func networkMetrics() []*metrics.Family {
+	ndev, err := NetworkInterfaces()
+	if err != nil {
+		log.Printf("metrics: cannot read netdev: %s", err.Error())
+		return nil
+	}
+
+	rxBytes := metrics.NewCounter(metricsPrefix+"network_receive_bytes_total", "Received bytes.")
+	txBytes := metrics.NewCounter(metricsPrefix+"network_transmit_bytes_total", "Transmitted bytes.")
+	rxPackets := metrics.NewCounter(metricsPrefix+"network_receive_packets_total", "Received packets.")
+	txPackets := metrics.NewCounter(metricsPrefix+"network_transmit_packets_total", "Transmitted packets.")
+	rxErrs := metrics.NewCounter(metricsPrefix+"network_receive_errs_total", "Receive errors.")
+	txErrs := metrics.NewCounter(metricsPrefix+"network_transmit_errs_total", "Transmit errors.")
+	rxDrop := metrics.NewCounter(metricsPrefix+"network_receive_drop_total", "Packets dropped while receiving.")
+	txDrop := metrics.NewCounter(metricsPrefix+"network_transmit_drop_total", "Packets dropped while transmitting.")
+
+	for _, l := range ndev {
+		rxBytes.Add(float64(l.RxBytes), "interface", l.Name)
+		txBytes.Add(float64(l.TxBytes), "interface", l.Name)
+		rxPackets.Add(float64(l.RxPackets), "interface", l.Name)
+		txPackets.Add(float64(l.TxPackets), "interface", l.Name)
+		rxErrs.Add(float64(l.RxErrors), "interface", l.Name)
+		txErrs.Add(float64(l.TxErrors), "interface", l.Name)
+		rxDrop.Add(float64(l.RxDropped), "interface", l.Name)
+		txDrop.Add(float64(l.TxDropped), "interface", l.Name)
+	}
+
+	return []*metrics.Family{rxBytes, txBytes, rxPackets, txPackets, rxErrs, txErrs, rxDrop, txDrop}
+}
+
+func mdMetrics() []*metrics.Family {
+	mds, err := MDStats()
+	if err != nil {
+		log.Printf("metrics: cannot read mdstat: %s", err.Error())
+		return nil
+	}
+
+	state := metrics.NewGauge(metricsPrefix+"md_state", "State of the md device, 1 for the current state.")
+	disks := metrics.NewGauge(metricsPrefix+"md_disks", "Number of disks of the md device by state.")
+	required := metrics.NewGauge(metricsPrefix+"md_disks_required", "Number of disks the md device requires.")
+	blocks := metrics.NewGauge(metricsPrefix+"md_blocks", "Number of blocks of the md device.")
+	synced := metrics.NewGauge(metricsPrefix+"md_blocks_synced", "Number of blocks of the md device that are in sync.")
+
+	for _, md := range mds {
+		state.Add(1, "device", md.Name, "state", md.ActivityState)
+		disks.Add(float64(md.DisksActive), "device", md.Name, "state", "active")
+		disks.Add(float64(md.DisksFailed), "device", md.Name, "state", "failed")
+		disks.Add(float64(md.DisksSpare), "device", md.Name, "state", "spare")
+		disks.Add(float64(md.DisksDown), "device", md.Name, "state", "down")
+		required.Add(float64(md.DisksTotal), "device", md.Name)
+		blocks.Add(float64(md.BlocksTotal), "device", md.Name)
+		synced.Add(float64(md.BlocksSynced), "device", md.Name)
+	}
+
+	return []*metrics.Family{state, disks, required, blocks, synced}
+}
+
+func filesystemMetrics() []*metrics.Family {
+	fs, err := Filesystems()
+	if err != nil {
+		log.Printf("metrics: cannot read filesystems: %s", err.Error())
+		return nil
+	}
+
+	size := metrics.NewGauge(metricsPrefix+"filesystem_size_bytes", "Filesystem size.")
+	free := metrics.NewGauge(metricsPrefix+"filesystem_avail_bytes", "Filesystem space available to non-root users.")
+	used := metrics.NewGauge(metricsPrefix+"filesystem_used_bytes", "Filesystem space used.")
+	files := metrics.NewGauge(metricsPrefix+"filesystem_files", "Filesystem total inodes.")
+	filesFree := metrics.NewGauge(metricsPrefix+"filesystem_files_free", "Filesystem free inodes.")
+
+	for mp, f := range fs {
+		size.Add(float64(f.SizeBytes), "mountpoint", mp, "fstype", f.Type)
+		free.Add(float64(f.FreeBytes), "mountpoint", mp, "fstype", f.Type)
+		used.Add(float64(f.UsedBytes), "mountpoint", mp, "fstype", f.Type)
+		files.Add(float64(f.Inodes), "mountpoint", mp, "fstype", f.Type)
+		filesFree.Add(float64(f.InodesFree), "mountpoint", mp, "fstype", f.Type)
+	}
+
+	return []*metrics.Family{size, free, used, files, filesFree}
+}
+
+func diskIOMetrics() []*metrics.Family {
+	devs, err := BlockDevices()
+	if err != nil {
+		log.Printf("metrics: cannot read diskstats: %s", err.Error())
+		return nil
+	}
+
+	readBytes := metrics.NewCounter(metricsPrefix+"disk_read_bytes_total", "Bytes read from the block device.")
+	writtenBytes := metrics.NewCounter(metricsPrefix+"disk_written_bytes_total", "Bytes written to the block device.")
+	reads := metrics.NewCounter(metricsPrefix+"disk_reads_completed_total", "Reads completed by the block device.")
+	writes := metrics.NewCounter(metricsPrefix+"disk_writes_completed_total", "Writes completed by the block device.")
+	readTime := metrics.NewCounter(metricsPrefix+"disk_read_time_seconds_total", "Seconds spent by all reads.")
+	writeTime := metrics.NewCounter(metricsPrefix+"disk_write_time_seconds_total", "Seconds spent by all writes.")
+	ioTime := metrics.NewCounter(metricsPrefix+"disk_io_time_seconds_total", "Seconds the block device was busy doing I/O.")
+
+	for name, ds := range devs {
+		readBytes.Add(float64(ds.ReadSectors)*diskstatsSectorSize, "device", name)
+		writtenBytes.Add(float64(ds.WriteSectors)*diskstatsSectorSize, "device", name)
+		reads.Add(float64(ds.ReadIOs), "device", name)
+		writes.Add(float64(ds.WriteIOs), "device", name)
+		readTime.Add(float64(ds.ReadTicks)/1000, "device", name)
+		writeTime.Add(float64(ds.WriteTicks)/1000, "device", name)
+		ioTime.Add(float64(ds.IOsTotalTicks)/1000, "device", name)
+	}
+
+	return []*metrics.Family{readBytes, writtenBytes, reads, writes, readTime, writeTime, ioTime}
+}
+
+func temperatureMetrics(ctx context.Context) []*metrics.Family {
+	t, err := CPUTemperature(ctx)
+	if err != nil {
+		log.Printf("metrics: cannot read CPU temperature: %s", err.Error())
+		return nil
+	}
+
+	temp := metrics.NewGauge(metricsPrefix+"cpu_temperature_celsius", "CPU temperature.")
+	temp.Add(t)
+
+	sensors := metrics.NewGauge(metricsPrefix+"temperature_celsius", "Temperature reported by a sensor.")
+	for _, r := range Sensors.Temperatures(ctx) {
+		sensors.Add(r.Celsius, "sensor", r.Sensor)
+	}
+
+	return []*metrics.Family{temp, sensors}
+}
+
+func frequencyMetrics(ctx context.Context) []*metrics.Family {
+	fams := make([]*metrics.Family, 0)
+
+	freq := metrics.NewGauge(metricsPrefix+"cpu_frequency_hertz", "Current clock frequency.")
+	if arm, err := CPUFrequency(ctx); err == nil {
+		freq.Add(arm, "clock", "arm")
+	}
+	// core clock and voltage are only available through vcgencmd
+	vcgencmd := hasVcgencmd()
+	if vcgencmd {
+		if core, err := ClockFrequency(ctx, "core"); err == nil {
+			freq.Add(core, "clock", "core")
+		}
+	}
+	if len(freq.Metrics) > 0 {
+		fams = append(fams, freq)
+	}
+
+	if !vcgencmd {
+		return fams
+	}
+	if v, err := CoreVoltage(ctx); err == nil {
+		volts := metrics.NewGauge(metricsPrefix+"core_voltage_volts", "Voltage of the SoC core.")
+		volts.Add(v)
+		fams = append(fams, volts)
+	}
+
+	return fams
+}
+
+func throttleMetrics(ctx context.Context) []*metrics.Family {
+	// get_throttled is only available on Raspberry Pi OS
+	if !hasVcgencmd() {
+		return nil
+	}
+	ts, err := CPUThrottlingStatus(ctx)
+	if err != nil {
+		log.Printf("metrics: cannot read throttling status: %s", err.Error())
+		return nil
+	}
+
+	current := metrics.NewGauge(metricsPrefix+"throttle_status", "Throttling flags reported by get_throttled, 1 if currently set.")
+	current.Add(metrics.Bool(ts.UnderVoltage), "flag", "under_voltage")
+	current.Add(metrics.Bool(ts.CurrentlyThrottled), "flag", "throttled")
+	current.Add(metrics.Bool(ts.ArmFrequencyCapped), "flag", "arm_frequency_capped")
+	current.Add(metrics.Bool(ts.SoftTemperatureReached), "flag", "soft_temperature_limit")
+
+	sinceReboot := metrics.NewGauge(metricsPrefix+"throttle_since_reboot", "Throttling flags reported by get_throttled, 1 if set since the last reboot.")
+	sinceReboot.Add(metrics.Bool(ts.UnderVoltageSinceReboot), "flag", "under_voltage")
+	sinceReboot.Add(metrics.Bool(ts.ThrottledSinceReboot), "flag", "throttled")
+	sinceReboot.Add(metrics.Bool(ts.ArmFrequencyCappedSinceReboot), "flag", "arm_frequency_capped")
+	sinceReboot.Add(metrics.Bool(ts.SoftTemperatureReachedSinceReboot), "flag", "soft_temperature_limit")
+
+	return []*metrics.Family{current, sinceReboot}
+}
+
+// Metrics reads all stats and returns them as metric families. Stats that
+// cannot be read are left out.
+func Metrics(ctx context.Context) []*metrics.Family {
+	fams := make([]*metrics.Family, 0)
+	fams = append(fams, loadMetrics()...)
+	fams = append(fams, cpuMetrics()...)
+	fams = append(fams, memoryMetrics()...)
+	fams = append(fams, networkMetrics()...)
+	fams = append(fams, mdMetrics()...)
+	fams = append(fams, filesystemMetrics()...)
+	fams = append(fams, diskIOMetrics()...)
+	fams = append(fams, temperatureMetrics(ctx)...)
+	fams = append(fams, throttleMetrics(ctx)...)
+	fams = append(fams, frequencyMetrics(ctx)...)
+
+	return fams
+}
@@ -0,0 +1,212 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/prometheus/procfs"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	networkColors = []color.Color{
+		color.RGBA{232, 141, 103, 255},
+		color.RGBA{43, 89, 195, 255},
+		color.RGBA{144, 186, 173, 255},
+		color.RGBA{211, 101, 130, 255},
+		color.RGBA{113, 124, 137, 255},
+		color.RGBA{187, 219, 155, 255},
+	}
+)
+
+// InterfaceSeries holds the rates of a single network interface. Rx and Tx
+// are in MiB/s, everything else per second.
+type InterfaceSeries struct {
+	Rx, Tx               *series.Series
+	RxPackets, TxPackets *series.Series
+	RxErrors, TxErrors   *series.Series
+	RxDropped, TxDropped *series.Series
+}
+
+func newInterfaceSeries(name string) *InterfaceSeries {
+	prefix := fmt.Sprintf("network.%s.", name)
+	return &InterfaceSeries{
+		Rx:        newSeries(prefix + "rx"),
+		Tx:        newSeries(prefix + "tx"),
+		RxPackets: newSeries(prefix + "rxPackets"),
+		TxPackets: newSeries(prefix + "txPackets"),
+		RxErrors:  newSeries(prefix + "rxErrors"),
+		TxErrors:  newSeries(prefix + "txErrors"),
+		RxDropped: newSeries(prefix + "rxDropped"),
+		TxDropped: newSeries(prefix + "txDropped"),
+	}
+}
+
+func (is *InterfaceSeries) all() []*series.Series {
+	return []*series.Series{
+		is.Rx, is.Tx,
+		is.RxPackets, is.TxPackets,
+		is.RxErrors, is.TxErrors,
+		is.RxDropped, is.TxDropped,
+	}
+}
+
+// NetworkPlot draws a pair of Rx/Tx lines for every interface that has been
+// discovered so far.
+type NetworkPlot struct {
+	mu         sync.RWMutex
+	Interfaces map[string]*InterfaceSeries
+}
+
+// Interface returns the series of the interface name, they are created the
+// first time an interface is seen.
+func (np *NetworkPlot) Interface(name string) *InterfaceSeries {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	is, ok := np.Interfaces[name]
+	if !ok {
+		is = newInterfaceSeries(name)
+		np.Interfaces[name] = is
+	}
+	return is
+}
+
+func (np *NetworkPlot) names() []string {
+	np.mu.RLock()
+	defer np.mu.RUnlock()
+
+	names := make([]string, 0, len(np.Interfaces))
+	for n := range np.Interfaces {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (np *NetworkPlot) Series() []*series.Series {
+	all := make([]*series.Series, 0)
+	for _, n := range np.names() {
+		all = append(all, np.Interface(n).all()...)
+	}
+	return all
+}
+
+func (np *NetworkPlot) PNG(n int) ([]byte, error) {
+	p := setupPlot("Network", n)
+	p.Y.Min = 0
+
+	for i, name := range np.names() {
+		is := np.Interface(name)
+		c := networkColors[i%len(networkColors)]
+
+		rxLine, err := plotter.NewLine(window(is.Rx, n))
+		if err != nil {
+			return []byte{}, err
+		}
+		rxLine.LineStyle = draw.LineStyle{
+			Color: c,
+			Width: 1.2,
+		}
+
+		txLine, err := plotter.NewLine(window(is.Tx, n))
+		if err != nil {
+			return []byte{}, err
+		}
+		txLine.LineStyle = draw.LineStyle{
+			Color:  c,
+			Width:  1.2,
+			Dashes: []vg.Length{vg.Points(4), vg.Points(2)},
+		}
+
+		p.Add(rxLine, txLine)
+		p.Legend.Add(name+" Rx", rxLine)
+		p.Legend.Add(name+" Tx", txLine)
+	}
+
+	return plotToPng(p)
+}
+
+// networkCollector computes per interface rates from the counters in
+// /proc/net/dev and the time that passed between two readings.
+type networkCollector struct {
+	prev     procfs.NetDev
+	prevTime time.Time
+}
+
+func (nc *networkCollector) Name() string {
+	return "network"
+}
+
+func (nc *networkCollector) Interval() time.Duration {
+	return plotInterval()
+}
+
+func (nc *networkCollector) Collect(ctx context.Context) ([]Sample, error) {
+	ndev, err := NetworkInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	prev, prevTime := nc.prev, nc.prevTime
+	nc.prev, nc.prevTime = ndev, now
+	if prev == nil {
+		return []Sample{}, nil
+	}
+
+	return networkSamples(NetworkRxTxPlot, prev, ndev, now.Sub(prevTime).Seconds()), nil
+}
+
+// networkSamples computes the rates of every interface in both prev and cur
+// over secs. Counters that went backwards, e.g. because the interface was
+// recreated, are skipped.
+func networkSamples(np *NetworkPlot, prev, cur procfs.NetDev, secs float64) []Sample {
+	samples := make([]Sample, 0)
+	if secs <= 0 {
+		return samples
+	}
+
+	rate := func(cur, prev uint64) (float64, bool) {
+		if cur < prev {
+			return 0, false
+		}
+		return float64(cur-prev) / secs, true
+	}
+
+	for name, c := range cur {
+		p, ok := prev[name]
+		if !ok {
+			continue
+		}
+
+		is := np.Interface(name)
+		for _, v := range []struct {
+			s         *series.Series
+			cur, prev uint64
+			scale     float64
+		}{
+			{is.Rx, c.RxBytes, p.RxBytes, 1024 * 1024},
+			{is.Tx, c.TxBytes, p.TxBytes, 1024 * 1024},
+			{is.RxPackets, c.RxPackets, p.RxPackets, 1},
+			{is.TxPackets, c.TxPackets, p.TxPackets, 1},
+			{is.RxErrors, c.RxErrors, p.RxErrors, 1},
+			{is.TxErrors, c.TxErrors, p.TxErrors, 1},
+			{is.RxDropped, c.RxDropped, p.RxDropped, 1},
+			{is.TxDropped, c.TxDropped, p.TxDropped, 1},
+		} {
+			if r, ok := rate(v.cur, v.prev); ok {
+				samples = append(samples, Sample{v.s, r / v.scale})
+			}
+		}
+	}
+
+	return samples
+}
@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/pbaettig/raspi-dash/series"
+	"github.com/prometheus/procfs"
+)
+
+func TestNetworkSamples(t *testing.T) {
+	np := &NetworkPlot{Interfaces: map[string]*InterfaceSeries{}}
+	prev := procfs.NetDev{
+		"eth0":    {Name: "eth0", RxBytes: 1 << 20, TxBytes: 1 << 20, RxPackets: 100, TxPackets: 100, RxErrors: 1, RxDropped: 2},
+		"wlan0":   {Name: "wlan0", RxBytes: 5000, TxBytes: 5000, RxPackets: 50, TxPackets: 50},
+		"docker0": {Name: "docker0"},
+	}
+	cur := procfs.NetDev{
+		// 2 seconds later
+		"eth0": {Name: "eth0", RxBytes: 5 << 20, TxBytes: 2 << 20, RxPackets: 300, TxPackets: 140, RxErrors: 3, RxDropped: 2},
+		// recreated, only the packets went up again
+		"wlan0": {Name: "wlan0", RxBytes: 10, TxBytes: 10, RxPackets: 52, TxPackets: 54},
+		// new, nothing to compare with
+		"tun0": {Name: "tun0", RxBytes: 1 << 20},
+	}
+
+	values := make(map[*series.Series]float64)
+	for _, s := range networkSamples(np, prev, cur, 2) {
+		if _, ok := values[s.Series]; ok {
+			t.Errorf("more than one sample for %s", s.Series.Name)
+		}
+		values[s.Series] = s.Value
+	}
+
+	eth0, wlan0 := np.Interface("eth0"), np.Interface("wlan0")
+	want := map[*series.Series]float64{
+		eth0.Rx:         2,
+		eth0.Tx:         0.5,
+		eth0.RxPackets:  100,
+		eth0.TxPackets:  20,
+		eth0.RxErrors:   1,
+		eth0.TxErrors:   0,
+		eth0.RxDropped:  0,
+		eth0.TxDropped:  0,
+		wlan0.RxPackets: 1,
+		wlan0.TxPackets: 2,
+		wlan0.RxErrors:  0,
+		wlan0.TxErrors:  0,
+		wlan0.RxDropped: 0,
+		wlan0.TxDropped: 0,
+	}
+	if len(values) != len(want) {
+		t.Errorf("expected %d samples, got %d", len(want), len(values))
+	}
+	for s, v := range want {
+		if got, ok := values[s]; !ok || got != v {
+			t.Errorf("%s is %v but should be %v", s.Name, got, v)
+		}
+	}
+	if _, ok := np.Interfaces["tun0"]; ok {
+		t.Errorf("a new interface shouldn't get series before it has a rate")
+	}
+
+	if samples := networkSamples(np, prev, cur, 0); len(samples) != 0 {
+		t.Errorf("expected no samples without time passing, got %v", samples)
+	}
+}
@@ -26,17 +26,16 @@ var (
 			Width: 1.5,
 		},
 	}
-	NetworkRxTxPlot NetworkPlot = NetworkPlot{
-		Rx: series.NewSeries("eth0 Rx", config.PlotDatapoints),
-		Tx: series.NewSeries("eth0 Tx", config.PlotDatapoints),
+	NetworkRxTxPlot *NetworkPlot = &NetworkPlot{
+		Interfaces: make(map[string]*InterfaceSeries),
 	}
 	LoadAvgPlot LoadPlot = LoadPlot{
-		Avg1:  series.NewSeries("avg1", config.PlotDatapoints),
-		Avg5:  series.NewSeries("avg5", config.PlotDatapoints),
-		Avg15: series.NewSeries("avg15", config.PlotDatapoints),
+		Avg1:  series.NewSeries("loadAvg.avg1", config.PlotDatapoints),
+		Avg5:  series.NewSeries("loadAvg.avg5", config.PlotDatapoints),
+		Avg15: series.NewSeries("loadAvg.avg15", config.PlotDatapoints),
 	}
 	MemoryUsedPlot SingleValuePlot = SingleValuePlot{
-		Value: series.NewSeries("used", config.PlotDatapoints),
+		Value: series.NewSeries("memoryUsage", config.PlotDatapoints),
 		Name:  "Memory Usage",
 		YMin:  0,
 		YMax:  100,
@@ -45,118 +44,44 @@ var (
 			Width: 1.2,
 		},
 	}
-	DiskUsagePlot DiskPlot = DiskPlot{
-		Data: series.NewSeries("data", config.PlotDatapoints),
-		Root: series.NewSeries("root", config.PlotDatapoints),
-		Boot: series.NewSeries("boot", config.PlotDatapoints),
+	DiskUsagePlot *DiskPlot = &DiskPlot{
+		Mounts: make(map[string]*MountSeries),
+	}
+	DiskIOPlot *BlockDevicePlot = &BlockDevicePlot{
+		Devices: make(map[string]*BlockDeviceSeries),
 	}
 	AllPlots map[string]StatPlotter = map[string]StatPlotter{
 		"cpuTemp":     &CPUTemperaturePlot,
-		"network":     &NetworkRxTxPlot,
+		"network":     NetworkRxTxPlot,
 		"loadAvg":     &LoadAvgPlot,
 		"memoryUsage": &MemoryUsedPlot,
-		"diskUsage":   &DiskUsagePlot,
+		"diskUsage":   DiskUsagePlot,
+		"diskInodes":  DiskInodesPlot{DiskUsagePlot},
+		"cpu":         CPUUsagePlot,
+		"diskIO":      DiskIOPlot,
+		"diskUtil":    BlockDeviceUtilisationPlot{DiskIOPlot},
+		"throttling":  ThrottlingPlot,
+		"cpuFreq":     CPUFrequencyPlot,
+		"coreVoltage": &CoreVoltagePlot,
 	}
 )
 
 type StatPlotter interface {
 	PNG(n int) ([]byte, error)
+	Series() []*series.Series
 	// AddPoint(t time.Time, v ...float64)
 }
 
-type DiskPlot struct {
-	Data *series.Series
-	Root *series.Series
-	Boot *series.Series
-}
-
-func (dp DiskPlot) PNG(n int) ([]byte, error) {
-	dataLine, err := plotter.NewLine(dp.Data.Datapoints.Last(n))
-	if err != nil {
-		return []byte{}, err
-	}
-	dataLine.LineStyle = draw.LineStyle{
-		Color: color.RGBA{43, 89, 195, 255},
-		Width: 1.2,
-	}
-
-	rootLine, err := plotter.NewLine(dp.Root.Datapoints.Last(n))
-	if err != nil {
-		return []byte{}, err
-	}
-	rootLine.LineStyle = draw.LineStyle{
-		Color: color.RGBA{211, 101, 130, 255},
-		Width: 1.2,
-	}
-
-	bootLine, err := plotter.NewLine(dp.Boot.Datapoints.Last(n))
-	if err != nil {
-		return []byte{}, err
-	}
-	bootLine.LineStyle = draw.LineStyle{
-		Color: color.RGBA{187, 219, 155, 255},
-		Width: 1.2,
-	}
-
-	p := setupPlot("Disk Usage")
-	p.Y.Min = 0
-	p.Y.Max = 100
-	p.Add(bootLine, dataLine, rootLine)
-
-	p.Legend.Add("boot", bootLine)
-	p.Legend.Add("data", dataLine)
-	p.Legend.Add("root", rootLine)
-	return plotToPng(p)
-}
-
-type NetworkPlot struct {
-	Rx *series.Series
-	Tx *series.Series
-}
-
-func (np NetworkPlot) PNG(n int) ([]byte, error) {
-	rxLine, err := plotter.NewLine(np.Rx.Datapoints.Last(n))
-	if err != nil {
-		return []byte{}, err
-	}
-	rxLine.LineStyle = draw.LineStyle{
-		Color: color.RGBA{232, 141, 103, 255},
-		Width: 1.2,
-	}
-
-	txLine, err := plotter.NewLine(np.Tx.Datapoints.Last(n))
-	if err != nil {
-		return []byte{}, err
-	}
-	txLine.LineStyle = draw.LineStyle{
-		Color: color.RGBA{153, 153, 195, 255},
-		Width: 1.2,
-	}
-
-	p := setupPlot("Network")
-	p.Y.Min = 0
-
-	p.Add(rxLine, txLine)
-	p.Legend.Add("Rx", rxLine)
-	p.Legend.Add("Tx", txLine)
-	return plotToPng(p)
-}
-
-// AddPoint Rx, TX
-func (np NetworkPlot) AddPoint(t time.Time, v ...float64) {
-	if len(v) != 2 {
-		panic("not enough values to add point for NetworkPlot")
-	}
-	np.Rx.Datapoints.Push(t, v[0])
-	np.Tx.Datapoints.Push(t, v[1])
-}
-
 type LoadPlot struct {
 	Avg1, Avg5, Avg15 *series.Series
 }
 
+func (lp LoadPlot) Series() []*series.Series {
+	return []*series.Series{lp.Avg1, lp.Avg5, lp.Avg15}
+}
+
 func (lp LoadPlot) PNG(n int) ([]byte, error) {
-	avg1Line, err := plotter.NewLine(lp.Avg1.Datapoints.Last(n))
+	avg1Line, err := plotter.NewLine(window(lp.Avg1, n))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -165,7 +90,7 @@ func (lp LoadPlot) PNG(n int) ([]byte, error) {
 		Width: 1.2,
 	}
 
-	avg5Line, err := plotter.NewLine(lp.Avg5.Datapoints.Last(n))
+	avg5Line, err := plotter.NewLine(window(lp.Avg5, n))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -174,7 +99,7 @@ func (lp LoadPlot) PNG(n int) ([]byte, error) {
 		Width: 1.2,
 	}
 
-	avg15Line, err := plotter.NewLine(lp.Avg15.Datapoints.Last(n))
+	avg15Line, err := plotter.NewLine(window(lp.Avg15, n))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -183,7 +108,7 @@ func (lp LoadPlot) PNG(n int) ([]byte, error) {
 		Width: 1.2,
 	}
 
-	p := setupPlot("Load Average")
+	p := setupPlot("Load Average", n)
 	p.Add(avg1Line, avg5Line, avg15Line)
 	p.Legend.Add("Avg1", avg1Line)
 	p.Legend.Add("Avg5", avg5Line)
@@ -197,9 +122,9 @@ func (lp LoadPlot) AddPoint(t time.Time, v ...float64) {
 	if len(v) != 3 {
 		panic("not enough values to add point for LoadPlot")
 	}
-	lp.Avg1.Datapoints.Push(t, v[0])
-	lp.Avg5.Datapoints.Push(t, v[1])
-	lp.Avg15.Datapoints.Push(t, v[2])
+	lp.Avg1.Push(t, v[0])
+	lp.Avg5.Push(t, v[1])
+	lp.Avg15.Push(t, v[2])
 }
 
 type SingleValuePlot struct {
@@ -209,8 +134,12 @@ type SingleValuePlot struct {
 	YMin, YMax float64
 }
 
+func (sp SingleValuePlot) Series() []*series.Series {
+	return []*series.Series{sp.Value}
+}
+
 func (sp SingleValuePlot) PNG(n int) ([]byte, error) {
-	line, err := plotter.NewLine(sp.Value.Datapoints.Last(n))
+	line, err := plotter.NewLine(window(sp.Value, n))
 	if err != nil {
 		return []byte{}, err
 	}
@@ -218,7 +147,7 @@ func (sp SingleValuePlot) PNG(n int) ([]byte, error) {
 		line.LineStyle = *sp.LineStyle
 	}
 
-	p := setupPlot(sp.Name)
+	p := setupPlot(sp.Name, n)
 	if sp.YMax > 0 {
 		p.Y.Min = 0
 		p.Y.Max = 100
@@ -234,10 +163,38 @@ func (sp SingleValuePlot) AddPoint(t time.Time, v ...float64) {
 	if len(v) != 1 {
 		panic("not enough values to add point for SingleValuePlot")
 	}
-	sp.Value.Datapoints.Push(t, v[0])
+	sp.Value.Push(t, v[0])
 }
 
-func setupPlot(title string) *plot.Plot {
+// AllSeries returns every series of every plot in AllPlots, keyed by the
+// series name.
+func AllSeries() map[string]*series.Series {
+	all := make(map[string]*series.Series)
+	for _, p := range AllPlots {
+		for _, s := range p.Series() {
+			all[s.Name] = s
+		}
+	}
+	return all
+}
+
+// window returns the datapoints of the last n intervals of s, or all raw
+// datapoints if n is negative. Windows longer than the raw datapoints
+// reach are served from the rollups of s.
+func window(s *series.Series, n int) plotter.XYs {
+	return windowAgg(s, n, series.Avg)
+}
+
+// windowAgg is like window but lets the caller pick the aggregate used for
+// rollups.
+func windowAgg(s *series.Series, n int, agg series.Aggregate) plotter.XYs {
+	if n < 0 {
+		return s.Datapoints.All()
+	}
+	return s.Since(time.Now().Add(-time.Duration(n)*settings().Plots.UpdateInterval), agg)
+}
+
+func setupPlot(title string, n int) *plot.Plot {
 	p := plot.New()
 
 	p.Title.TextStyle.Color = config.PlotTitleFontColor
@@ -249,7 +206,7 @@ func setupPlot(title string) *plot.Plot {
 
 	p.X.Tick.Marker = plot.TimeTicks{
 		Ticker: nil,
-		Format: "15:04:05",
+		Format: timeFormat(n),
 		Time: func(t float64) time.Time {
 			return time.Unix(int64(t), 0)
 		},
@@ -260,12 +217,20 @@ func setupPlot(title string) *plot.Plot {
 	return p
 }
 
+func timeFormat(n int) string {
+	if n < 0 || time.Duration(n)*settings().Plots.UpdateInterval <= 24*time.Hour {
+		return "15:04:05"
+	}
+	return "02.01. 15:04"
+}
+
 func plotToPng(p *plot.Plot) ([]byte, error) {
 	if p == nil {
 		log.Fatalln("oops")
 	}
 
-	wt, err := p.WriterTo(config.PlotSizeWidth, config.PlotSizeHeight, "png")
+	pc := settings().Plots
+	wt, err := p.WriterTo(vg.Points(float64(pc.Width)), vg.Points(float64(pc.Height)), "png")
 	if err != nil {
 		return []byte{}, err
 	}
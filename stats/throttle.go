@@ -0,0 +1,170 @@
+package stats
+
+import (
+	"context"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/pbaettig/raspi-dash/config"
+	"github.com/pbaettig/raspi-dash/series"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+var (
+	ThrottlingPlot = &ThrottlePlot{
+		UnderVoltage:           series.NewSeries("throttle.underVoltage", config.PlotDatapoints),
+		Throttled:              series.NewSeries("throttle.throttled", config.PlotDatapoints),
+		ArmFrequencyCapped:     series.NewSeries("throttle.armFrequencyCapped", config.PlotDatapoints),
+		SoftTemperatureReached: series.NewSeries("throttle.softTemperatureReached", config.PlotDatapoints),
+	}
+
+	latestThrottle   throttleStatus
+	latestThrottleMu sync.RWMutex
+
+	throttleColors = []color.Color{
+		color.RGBA{216, 87, 42, 255},
+		color.RGBA{211, 101, 130, 255},
+		color.RGBA{232, 141, 103, 255},
+		color.RGBA{43, 89, 195, 255},
+	}
+)
+
+// ThrottlePlot is a timeline with a row for every get_throttled flag. A bar
+// is drawn wherever the flag was set.
+type ThrottlePlot struct {
+	UnderVoltage           *series.Series
+	Throttled              *series.Series
+	ArmFrequencyCapped     *series.Series
+	SoftTemperatureReached *series.Series
+}
+
+func (tp *ThrottlePlot) Series() []*series.Series {
+	return []*series.Series{tp.UnderVoltage, tp.Throttled, tp.ArmFrequencyCapped, tp.SoftTemperatureReached}
+}
+
+// runs returns start and end of every stretch of xys with Y > 0. A stretch
+// ends where the next datapoint begins, so single datapoints are visible.
+func runs(xys plotter.XYs) [][2]float64 {
+	rs := make([][2]float64, 0)
+	start := -1
+
+	for i, xy := range xys {
+		if xy.Y > 0 && start < 0 {
+			start = i
+		}
+		if xy.Y <= 0 && start >= 0 {
+			rs = append(rs, [2]float64{xys[start].X, xy.X})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		end := xys[len(xys)-1].X
+		if end == xys[start].X {
+			end++
+		}
+		rs = append(rs, [2]float64{xys[start].X, end})
+	}
+
+	return rs
+}
+
+func (tp *ThrottlePlot) PNG(n int) ([]byte, error) {
+	p := setupPlot("Throttling", n)
+	p.Y.Min = -0.5
+	p.Y.Max = 3.5
+
+	labels := []string{"under-voltage", "throttled", "freq. capped", "soft temp. limit"}
+	ticks := make([]plot.Tick, len(labels))
+
+	for row, s := range tp.Series() {
+		ticks[row] = plot.Tick{Value: float64(row), Label: labels[row]}
+
+		// any time the flag was set within a rollup bucket counts
+		for _, r := range runs(windowAgg(s, n, series.Max)) {
+			poly, err := plotter.NewPolygon(plotter.XYs{
+				{X: r[0], Y: float64(row) - 0.35},
+				{X: r[1], Y: float64(row) - 0.35},
+				{X: r[1], Y: float64(row) + 0.35},
+				{X: r[0], Y: float64(row) + 0.35},
+			})
+			if err != nil {
+				return []byte{}, err
+			}
+			poly.Color = throttleColors[row%len(throttleColors)]
+			poly.LineStyle = draw.LineStyle{}
+			p.Add(poly)
+		}
+	}
+	p.Y.Tick.Marker = plot.ConstantTicks(ticks)
+
+	// keep the time axis even if nothing was ever set
+	if n >= 0 {
+		now := float64(time.Now().Unix())
+		p.X.Min = now - float64(time.Duration(n)*settings().Plots.UpdateInterval/time.Second)
+		p.X.Max = now
+	}
+
+	return plotToPng(p)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// LatestThrottleStatus returns the get_throttled flags of the most recent
+// run of the throttle collector.
+func LatestThrottleStatus() throttleStatus {
+	latestThrottleMu.RLock()
+	defer latestThrottleMu.RUnlock()
+
+	return latestThrottle
+}
+
+// throttleWarnings describes every flag of ts that has been set since the
+// last reboot.
+func throttleWarnings(ts throttleStatus) []string {
+	ws := make([]string, 0)
+	if ts.UnderVoltageSinceReboot {
+		ws = append(ws, "under-voltage has occurred since last reboot")
+	}
+	if ts.ThrottledSinceReboot {
+		ws = append(ws, "throttling has occurred since last reboot")
+	}
+	if ts.ArmFrequencyCappedSinceReboot {
+		ws = append(ws, "arm frequency capped has occurred since last reboot")
+	}
+	if ts.SoftTemperatureReachedSinceReboot {
+		ws = append(ws, "soft temperature limit reached since last reboot")
+	}
+	return ws
+}
+
+var (
+	throttleCollector = collectorFunc{
+		name:     "throttle",
+		interval: plotInterval,
+		collect: func(ctx context.Context) ([]Sample, error) {
+			ts, err := CPUThrottlingStatus(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			latestThrottleMu.Lock()
+			latestThrottle = ts
+			latestThrottleMu.Unlock()
+
+			return []Sample{
+				{ThrottlingPlot.UnderVoltage, boolToFloat(ts.UnderVoltage)},
+				{ThrottlingPlot.Throttled, boolToFloat(ts.CurrentlyThrottled)},
+				{ThrottlingPlot.ArmFrequencyCapped, boolToFloat(ts.ArmFrequencyCapped)},
+				{ThrottlingPlot.SoftTemperatureReached, boolToFloat(ts.SoftTemperatureReached)},
+			}, nil
+		},
+	}
+)
@@ -0,0 +1,28 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestRuns(t *testing.T) {
+	tests := []struct {
+		name string
+		xys  plotter.XYs
+		want [][2]float64
+	}{
+		{"empty", plotter.XYs{}, [][2]float64{}},
+		{"never throttled", plotter.XYs{{X: 0, Y: 0}, {X: 1, Y: 0}}, [][2]float64{}},
+		{"single datapoint", plotter.XYs{{X: 0, Y: 0}, {X: 1, Y: 1}, {X: 2, Y: 0}}, [][2]float64{{1, 2}}},
+		{"two stretches", plotter.XYs{{X: 0, Y: 1}, {X: 1, Y: 1}, {X: 2, Y: 0}, {X: 3, Y: 1}, {X: 5, Y: 0}}, [][2]float64{{0, 2}, {3, 5}}},
+		{"until the end", plotter.XYs{{X: 0, Y: 0}, {X: 1, Y: 1}, {X: 2, Y: 1}}, [][2]float64{{1, 2}}},
+		{"only the last datapoint", plotter.XYs{{X: 0, Y: 0}, {X: 1, Y: 1}}, [][2]float64{{1, 2}}},
+	}
+	for _, tt := range tests {
+		if got := runs(tt.xys); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v but should be %v", tt.name, got, tt.want)
+		}
+	}
+}
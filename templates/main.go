@@ -7,8 +7,10 @@ import (
 	"log"
 	"time"
 
+	"github.com/pbaettig/raspi-dash/alerts"
 	"github.com/pbaettig/raspi-dash/borg"
-	"github.com/prometheus/procfs"
+	"github.com/pbaettig/raspi-dash/letsencrypt"
+	"github.com/pbaettig/raspi-dash/raid"
 )
 
 type IndexPageData struct {
@@ -17,10 +19,18 @@ type IndexPageData struct {
 	LoadAvg15      string
 	CPUTemp        string
 	Plots          map[string]string
-	RaidStats      []procfs.MDStat
+	RaidArrays     []raid.Array
+	RaidDegraded   []raid.Array
+	RaidHistory    []raid.Transition
 	Backups        map[string][]borg.Archive
 	RangeSliderMin int
 	RangeSliderMax int
+
+	Alerts           []alerts.Alert
+	StaleAlerts      []alerts.Alert
+	CollectorErrors  map[string]string
+	ThrottleWarnings []string
+	Certificate      *letsencrypt.Status
 }
 
 func fmtDuration(d time.Duration) string {